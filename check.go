@@ -0,0 +1,194 @@
+package smc
+
+import (
+	"sort"
+	"strings"
+	"text/scanner"
+	"unicode"
+)
+
+type Warning struct {
+	Pos     scanner.Position
+	Kind    string
+	Message string
+}
+
+func (warning *Warning) String() string {
+	return warning.Pos.String() + ": " + warning.Message + " [" + warning.Kind + "]"
+}
+
+func Directive(comment string) ([]string, bool) {
+	var text = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(comment, "//"), "/*"), "*/")
+	var fields = strings.FieldsFunc(text, func(chr rune) bool {
+		return chr == ',' || unicode.IsSpace(chr)
+	})
+	if len(fields) == 0 || fields[0] != "smc:ignore" {
+		return nil, false
+	}
+	if len(fields) == 1 {
+		return []string{"all"}, true
+	}
+	return fields[1:], true
+}
+
+func PrintIgnore(ignore []string, indent string) []string {
+	if ignore == nil {
+		return nil
+	}
+	return []string{indent + "// smc:ignore " + strings.Join(ignore, ", ")}
+}
+
+func Ignores(ignore []string, kind string) bool {
+	for _, str := range ignore {
+		if str == kind || str == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+func Check(root *State) []*Warning {
+	var warnings []*Warning
+	var reported = make(map[Warning]bool)
+	var warn = func(pos scanner.Position, ignore []string, kind, message string) {
+		var warning = Warning{pos, kind, message}
+		if Ignores(ignore, kind) == false && reported[warning] == false {
+			reported[warning] = true
+			warnings = append(warnings, &warning)
+		}
+	}
+	var (
+		declared []*Event
+		seen     = make(map[scanner.Position]bool)
+		present  = make(map[scanner.Position]bool)
+		fires    = make(map[scanner.Position]bool)
+		reached  = make(map[*State]bool)
+		conds    = make(map[string][]*Event)
+	)
+	for _, state := range root.AllDescendants(root) {
+		for _, event := range state.Events() {
+			if seen[event.Pos()] == false {
+				seen[event.Pos()] = true
+				declared = append(declared, event)
+			}
+		}
+	}
+	var satisfiable = func(cond *Cond, prior []*Cond) bool {
+		var names []string
+		if cond != nil {
+			names = cond.Names()
+		}
+		for _, other := range prior {
+			names = append(names, other.Names()...)
+		}
+		var _, ok = Satisfy(cond, prior)
+		return ok || len(StringSet(names)) > 16
+	}
+	var queue []*State
+	var reach = func(dsts []*State) {
+		for _, dst := range dsts {
+			if reached[dst] == false {
+				reached[dst] = true
+				queue = append(queue, dst)
+			}
+		}
+	}
+	var _, start = MakeStart(root)
+	reach(start)
+	for len(queue) != 0 {
+		var state = queue[0]
+		queue = queue[1:]
+		var events = state.Events()
+		if completion := MakeCompletion(state); completion != nil {
+			events = completion.EventsGrouped()["done"]
+		}
+		for _, event := range events {
+			if event.Dst() != nil && event.Dst().IsChoice() {
+				reached[event.Dst()] = true
+			}
+			for _, branch := range MakeBranches(event) {
+				reach(branch.Dsts)
+			}
+		}
+	}
+	for _, state := range root.AllDescendants(root) {
+		if state.IsChoice() && reached[state] == false {
+			warn(state.Pos(), state.ignore, "unreachable", "choice "+state.Name()+" is unreachable")
+		}
+		if state.IsAtomic() == false {
+			continue
+		}
+		if reached[state] == false {
+			warn(state.Pos(), state.ignore, "unreachable", "state "+state.Name()+" is unreachable")
+		}
+		var trap = true
+		for _, events := range state.EventsGrouped() {
+			var guard, fallback *Event
+			var prior []*Cond
+			for _, event := range events {
+				present[event.Pos()] = true
+				if fallback == nil && satisfiable(event.Cond(), prior) {
+					fires[event.Pos()] = true
+				}
+				if event.HasCond() {
+					prior = append(prior, event.Cond())
+				}
+				if event.HasCond() && guard == nil {
+					guard = event
+				}
+				if event.HasCond() == false && fallback == nil {
+					fallback = event
+				}
+				for _, branch := range MakeBranches(event) {
+					if len(branch.Dsts) != 0 {
+						trap = false
+					}
+				}
+			}
+			if guard != nil && fallback == nil {
+				warn(guard.Pos(), guard.ignore, "else", "event "+guard.Name()+" has guards but no else")
+			}
+			if guard != nil && fallback != nil && fallback.IsElse() == false {
+				warn(guard.Pos(), guard.ignore, "else", "event "+guard.Name()+" falls back to the handler at "+fallback.Pos().String()+", use else")
+			}
+			if guard == nil && fallback != nil && fallback.IsElse() {
+				warn(fallback.Pos(), fallback.ignore, "else", "event "+fallback.Name()+" has else but no guards")
+			}
+		}
+		for parent := state.Parent(); trap && parent != nil; parent = parent.Parent() {
+			if parent.IsParallel() {
+				for _, event := range parent.Events() {
+					if _, dsts := MakeTransition(event); len(dsts) != 0 && state.EventsGrouped()[event.Name()] == nil {
+						trap = false
+					}
+				}
+			}
+		}
+		if trap && state.IsFinal() == false {
+			warn(state.Pos(), state.ignore, "trap", "state "+state.Name()+" has no outgoing transitions")
+		}
+	}
+	for _, event := range declared {
+		switch {
+		case event.IsDone(), event.IsBranch():
+		case present[event.Pos()] == false:
+			warn(event.Pos(), event.ignore, "shadowed", "event "+event.Name()+" is overridden in every substate of "+event.Src().Name())
+		case fires[event.Pos()] == false:
+			warn(event.Pos(), event.ignore, "dead", "event "+event.Name()+" can never fire, the handlers before it cover every case")
+		}
+		if event.HasCond() && event.IsBranch() == false {
+			for _, cond := range StringSet(event.Cond().Names()) {
+				conds[cond] = append(conds[cond], event)
+			}
+		}
+	}
+	for cond, events := range conds {
+		if len(events) == 1 {
+			warn(events[0].Pos(), events[0].ignore, "condition", "condition "+cond+" is used on a single event")
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return Before(warnings[i].Pos, warnings[j].Pos)
+	})
+	return warnings
+}
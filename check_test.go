@@ -0,0 +1,112 @@
+package smc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckParallelFallback(t *testing.T) {
+	var src = `x.M {
+	start Run;
+	state Run {
+		region Conn {
+			start Down;
+			state Down { event Go { dst Up; } }
+			state Up { event Kill { act Log; } }
+		}
+		event Kill { dst Off; }
+	}
+	final Off;
+}`
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.PushEvents()
+	var list []string
+	for _, warning := range Check(root) {
+		list = append(list, warning.String())
+	}
+	if text := strings.Join(list, "\n"); text != "test.sm:7:10: state Up has no outgoing transitions [trap]" {
+		t.Errorf("unexpected warnings:\n%s", text)
+	}
+}
+
+func TestCheckIgnoreSurvivesPrint(t *testing.T) {
+	var src = `x.M {
+	start A;
+	// smc:ignore trap
+	state A {
+		event Go if C { act X; } // smc:ignore else, condition
+	}
+	// smc:ignore
+	state B;
+}`
+	for pass := 0; pass < 2; pass++ {
+		var root, err = Parse(strings.NewReader(src), "test.sm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		src = strings.Join(PrintRoot(root, ""), "\n")
+		root.PushEvents()
+		for _, warning := range Check(root) {
+			t.Errorf("pass %d: unexpected warning %s", pass, warning)
+		}
+	}
+}
+
+func TestCheckWarnings(t *testing.T) {
+	var src = `x.M {
+	start A;
+	state A {
+		event X if P { dst B; }
+		event X if P && Q { dst C; }
+		event X else { dst A; }
+		event Y if R { dst B; }
+		event Z if S { dst C; }
+		event Z { dst B; }
+		event V if P { dst B; }
+		event V if !P { dst C; }
+		event V else { dst A; }
+	}
+	state B { event Y { dst A; } }
+	state C;
+	state D;
+	choice K { if P { dst A; } else { dst B; } }
+	state E {
+		start E1;
+		event W { dst A; }
+		state E1 { event W { dst B; } }
+	}
+	state F { event U else { dst A; } }
+}`
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.PushEvents()
+	var want = []string{
+		"test.sm:5:9: event X can never fire, the handlers before it cover every case [dead]",
+		"test.sm:5:9: condition Q is used on a single event [condition]",
+		"test.sm:7:9: event Y has guards but no else [else]",
+		"test.sm:7:9: condition R is used on a single event [condition]",
+		"test.sm:8:9: event Z falls back to the handler at test.sm:9:9, use else [else]",
+		"test.sm:8:9: condition S is used on a single event [condition]",
+		"test.sm:12:9: event V can never fire, the handlers before it cover every case [dead]",
+		"test.sm:15:8: state C has no outgoing transitions [trap]",
+		"test.sm:16:8: state D is unreachable [unreachable]",
+		"test.sm:16:8: state D has no outgoing transitions [trap]",
+		"test.sm:17:9: choice K is unreachable [unreachable]",
+		"test.sm:20:9: event W is overridden in every substate of E [shadowed]",
+		"test.sm:21:9: state E1 is unreachable [unreachable]",
+		"test.sm:23:8: state F is unreachable [unreachable]",
+		"test.sm:23:18: event U has else but no guards [else]",
+	}
+	var list []string
+	for _, warning := range Check(root) {
+		list = append(list, warning.String())
+	}
+	if got := strings.Join(list, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("expecting\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+}
@@ -0,0 +1,99 @@
+package smc
+
+type Cond struct {
+	op   string
+	name string
+	args []*Cond
+}
+
+func (cond *Cond) Name() string {
+	return cond.name
+}
+
+func (cond *Cond) precedence() int {
+	switch cond.op {
+	case "||":
+		return 1
+	case "&&":
+		return 2
+	case "!":
+		return 3
+	}
+	return 4
+}
+
+func (cond *Cond) Format(name func(string) string) string {
+	var operand = func(arg *Cond) string {
+		if arg.precedence() < cond.precedence() || (cond.op == "!" && arg.op != "") {
+			return "(" + arg.Format(name) + ")"
+		}
+		return arg.Format(name)
+	}
+	switch cond.op {
+	case "":
+		return name(cond.name)
+	case "!":
+		return "!" + operand(cond.args[0])
+	}
+	return operand(cond.args[0]) + " " + cond.op + " " + operand(cond.args[1])
+}
+
+func (cond *Cond) String() string {
+	return cond.Format(func(name string) string {
+		return name
+	})
+}
+
+func (cond *Cond) Names() []string {
+	if cond.op == "" {
+		return []string{cond.name}
+	}
+	var names []string
+	for _, arg := range cond.args {
+		names = append(names, arg.Names()...)
+	}
+	return names
+}
+
+func (cond *Cond) Eval(values map[string]bool) bool {
+	switch cond.op {
+	case "!":
+		return !cond.args[0].Eval(values)
+	case "&&":
+		return cond.args[0].Eval(values) && cond.args[1].Eval(values)
+	case "||":
+		return cond.args[0].Eval(values) || cond.args[1].Eval(values)
+	}
+	return values[cond.name]
+}
+
+func Satisfy(cond *Cond, prior []*Cond) (map[string]bool, bool) {
+	var names []string
+	if cond != nil {
+		names = cond.Names()
+	}
+	for _, other := range prior {
+		names = append(names, other.Names()...)
+	}
+	names = StringSet(names)
+	if len(names) > 16 {
+		names = names[:16]
+	}
+	for mask := 0; mask < 1<<len(names); mask++ {
+		var values = make(map[string]bool)
+		for idx, name := range names {
+			values[name] = mask&(1<<idx) != 0
+		}
+		if cond != nil && cond.Eval(values) == false {
+			continue
+		}
+		var shadowed = false
+		for _, other := range prior {
+			shadowed = shadowed || other.Eval(values)
+		}
+		if shadowed == false {
+			return values, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,57 @@
+package smc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCondFormat(t *testing.T) {
+	var tests = []struct {
+		src, text, names string
+	}{
+		{"A", "A", "A"},
+		{"A || B && C", "A || B && C", "A,B,C"},
+		{"(A || B) && C", "(A || B) && C", "A,B,C"},
+		{"A && (B || C)", "A && (B || C)", "A,B,C"},
+		{"((A && B)) || C", "A && B || C", "A,B,C"},
+		{"!A && B", "!A && B", "A,B"},
+		{"!(A && B)", "!(A && B)", "A,B"},
+		{"!!A", "!(!A)", "A"},
+		{"A || !(B || A)", "A || !(B || A)", "A,B,A"},
+	}
+	for _, test := range tests {
+		var src = fmt.Sprintf("x.M {\n\tstart A;\n\tstate A { event E if %s { act X; } }\n}", test.src)
+		var root, err = Parse(strings.NewReader(src), "test.sm")
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		var cond = root.Start().Events()[0].Cond()
+		if text := cond.String(); text != test.text {
+			t.Errorf("%s: expecting %s, got %s", test.src, test.text, text)
+		}
+		if names := strings.Join(cond.Names(), ","); names != test.names {
+			t.Errorf("%s: expecting names %s, got %s", test.src, test.names, names)
+		}
+	}
+}
+
+func TestCondEval(t *testing.T) {
+	var src = "x.M {\n\tstart A;\n\tstate A { event E if !(A && B) || C { act X; } }\n}"
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cond = root.Start().Events()[0].Cond()
+	if text := cond.Format(func(name string) string { return "Cond" + name + "()" }); text != "!(CondA() && CondB()) || CondC()" {
+		t.Errorf("unexpected format %s", text)
+	}
+	for mask := 0; mask < 8; mask++ {
+		var a, b, c = mask&1 != 0, mask&2 != 0, mask&4 != 0
+		var values = map[string]bool{"A": a, "B": b, "C": c}
+		if got, want := cond.Eval(values), !(a && b) || c; got != want {
+			t.Errorf("%v: expecting %v, got %v", values, want, got)
+		}
+	}
+}
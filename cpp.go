@@ -7,29 +7,147 @@ import (
 )
 
 func PrintCpp(file io.Writer, root *State, source []string) {
+	var name, ns = SplitName(root.Name())
+	var allhist = root.AllHistories()
+	var alldefer = root.AllDeferred()
 	var line = func(idt int, format string, args ...interface{}) {
 		fmt.Fprintf(file, strings.Repeat("\t", idt))
 		fmt.Fprintf(file, format, args...)
 		fmt.Fprintf(file, "\r\n")
 	}
-	var transition = func(idt int, event *Event) {
-		var actions, dst = MakeTransition(event)
-		if dst != nil && len(actions) != 0 {
-			line(idt, "parent->SetInvalidState();")
+	var args = func(params []Param, event *Event) string {
+		if len(params) == 0 {
+			return ""
+		}
+		return JoinParams(root.EventParams(event.Name()), "%[1]s")
+	}
+	var params = func(params []Param) string {
+		var list []string
+		for _, param := range params {
+			if param.Literal && param.Type == "string" {
+				list = append(list, "const char *"+param.Name)
+			} else {
+				list = append(list, param.Type+" "+param.Name)
+			}
+		}
+		return strings.Join(list, ", ")
+	}
+	var suffix = func(params []Param, format string) string {
+		if len(params) == 0 {
+			return ""
+		}
+		return ", " + JoinParams(params, format)
+	}
+	var current = func(slot *State) string {
+		if slot.IsRegion() {
+			return "Current" + Camel(slot.Name())
+		}
+		return "CurrentState"
+	}
+	var steps = func(idt int, event *Event, steps []Step) {
+		for _, step := range steps {
+			if step.Exit != nil {
+				line(idt, "parent->%s->Exit(parent);", current(step.Exit))
+			} else if step.Start != nil {
+				line(idt, "parent->StartTimer(\"%s\", std::chrono::milliseconds(%d), &%s::Fire%s);", Camel(step.Start.Name()), step.Start.Timeout().Milliseconds(), name, Camel(step.Start.Name()))
+			} else if step.Stop != nil {
+				line(idt, "parent->StopTimer(\"%s\");", Camel(step.Stop.Name()))
+			} else if step.Args != nil {
+				line(idt, "parent->On%s(%s);", Camel(step.Act), JoinArgs(step.Args))
+			} else {
+				line(idt, "parent->On%s(%s);", Camel(step.Act), args(root.ActionParams(step.Act), event))
+			}
+		}
+	}
+	var label = func(state *State) string {
+		if state.Parent() == nil {
+			return ""
 		}
-		for _, act := range actions {
-			line(idt, "parent->On%s();", Camel(act))
+		return Camel(state.Name())
+	}
+	var complete = func(idt int, parent string, dsts []*State) {
+		for _, dst := range dsts {
+			if state := MakeCompletion(dst); state != nil && state.EventsGrouped()["done"] != nil {
+				line(idt, "Complete%s(%s);", label(state), parent)
+			}
 		}
-		if dst != nil {
+	}
+	var branch = func(idt int, event *Event, list []Step, dsts []*State) {
+		if len(dsts) != 0 && len(list) != 0 {
+			if slot := event.Src().Region(); slot.IsRegion() {
+				line(idt, "parent->SetInvalidState(parent->%s);", current(slot))
+			} else {
+				line(idt, "parent->SetInvalidState();")
+			}
+		}
+		steps(idt, event, list)
+		for _, dst := range dsts {
 			line(idt, "parent->SetState%s();", Camel(dst.Name()))
 		}
+		complete(idt, "parent", dsts)
+		if alldefer != nil && len(dsts) != 0 {
+			line(idt, "parent->Replay();")
+		}
+	}
+	var guard = func(cond *Cond, event *Event) string {
+		return cond.Format(func(name string) string {
+			return "parent->Cond" + Camel(name) + "(" + args(root.CondParams(name), event) + ")"
+		})
+	}
+	var cond = func(event *Event) string {
+		return guard(event.Cond(), event)
+	}
+	var transition = func(idt int, event *Event) {
+		var branches = MakeBranches(event)
+		if len(branches) == 1 {
+			branch(idt, event, branches[0].Steps, branches[0].Dsts)
+			return
+		}
+		for idx, br := range branches {
+			var keys []string
+			for _, key := range br.Keys {
+				keys = append(keys, fmt.Sprintf("parent->History%s == &%s::SetState%s", Camel(event.Dst().Name()), name, Camel(key.Name())))
+			}
+			var test = strings.Join(keys, " || ")
+			if br.Cond != nil {
+				test = guard(br.Cond, event)
+			}
+			switch {
+			case idx == 0:
+				line(idt, "if (%s) {", test)
+			case test == "":
+				line(idt, "} else {")
+			default:
+				line(idt, "} else if (%s) {", test)
+			}
+			branch(idt+1, event, br.Steps, br.Dsts)
+		}
+		line(idt, "}")
 	}
-	var name, ns = SplitName(root.Name())
 	var allcond = root.AllConditions()
 	var allact = root.AllActions()
 	var allev = root.AllEvents()
+	var allsig = root.AllSignals()
+	var allreg = root.AllRegions()
+	var alltimer = root.AllTimers()
+	var payload = false
+	for _, ev := range allev {
+		payload = payload || len(root.EventParams(ev)) != 0
+	}
 	line(0, "#pragma once")
 	line(0, "")
+	if alltimer != nil {
+		line(0, "#include <chrono>")
+	}
+	if payload || alldefer != nil {
+		line(0, "#include <functional>")
+	}
+	if alldefer != nil {
+		line(0, "#include <vector>")
+	}
+	if alltimer != nil || payload || alldefer != nil {
+		line(0, "")
+	}
 	line(0, "/**")
 	line(0, strings.Join(source, "\r\n"))
 	line(0, "**/")
@@ -37,74 +155,169 @@ func PrintCpp(file io.Writer, root *State, source []string) {
 	line(0, "namespace %s {", strings.Join(ns, "::"))
 	line(1, "struct %s {", name)
 	for _, ev := range allev {
-		line(2, "void Send%s() {", Camel(ev))
-		line(3, "CurrentState->On%s(this);", Camel(ev))
+		var evparams = root.EventParams(ev)
+		line(2, "void Send%s(%s) {", Camel(ev), params(evparams))
+		line(3, "if (!CurrentState->On%s(this%s)) {", Camel(ev), suffix(evparams, "%[1]s"))
+		line(4, "OnUnhandled(\"%s\");", Camel(ev))
+		line(3, "}")
 		line(2, "}")
 	}
 	for _, ev := range allev {
-		line(2, "void Post%s() {", Camel(ev))
-		line(3, "PostEvent(&%s::Send%s);", name, Camel(ev))
+		var evparams = root.EventParams(ev)
+		line(2, "void Post%s(%s) {", Camel(ev), params(evparams))
+		if len(evparams) == 0 {
+			line(3, "PostEvent(&%s::Send%s);", name, Camel(ev))
+		} else {
+			line(3, "PostEvent(std::function<void()>([this%s]() {", suffix(evparams, "%[1]s"))
+			line(4, "Send%s(%s);", Camel(ev), JoinParams(evparams, "%[1]s"))
+			line(3, "}));")
+		}
 		line(2, "}")
 	}
 	line(2, "void Start() {")
 	line(3, "if (CurrentState == nullptr) {")
-	var actions, dst = MakeStart(root)
-	for _, act := range actions {
-		line(4, "On%s();", Camel(act))
+	var actions, dsts = MakeStart(root)
+	for _, step := range actions {
+		if step.Start != nil {
+			line(4, "StartTimer(\"%s\", std::chrono::milliseconds(%d), &%s::Fire%s);", Camel(step.Start.Name()), step.Start.Timeout().Milliseconds(), name, Camel(step.Start.Name()))
+		} else {
+			line(4, "On%s();", Camel(step.Act))
+		}
 	}
-	line(4, "SetState%s();", Camel(dst.Name()))
+	for _, dst := range dsts {
+		line(4, "SetState%s();", Camel(dst.Name()))
+	}
+	complete(4, "this", dsts)
 	line(3, "}")
 	line(2, "}")
+	if root.Finals() != nil {
+		line(2, "bool IsTerminated() const {")
+		line(3, "return Terminated;")
+		line(2, "}")
+	}
 	line(2, "using Event = void (%s::*)();", name)
 	line(1, "protected:")
 	for _, act := range allact {
-		line(2, "virtual void On%s() {", Camel(act))
+		line(2, "virtual void On%s(%s) {", Camel(act), params(root.ActionParams(act)))
 		line(2, "}")
 	}
 	for _, cond := range allcond {
-		line(2, "virtual bool Cond%s() const {", Camel(cond))
+		line(2, "virtual bool Cond%s(%s) const {", Camel(cond), params(root.CondParams(cond)))
 		line(3, "throw \"not implemented: Cond%s\";", Camel(cond))
 		line(2, "}")
 	}
+	line(2, "virtual void OnUnhandled(const char *name) {")
+	line(2, "}")
 	line(2, "virtual void PostEvent(Event event) {")
 	line(3, "throw \"not implemented: PostEvent\";")
 	line(2, "}")
+	if payload {
+		line(2, "virtual void PostEvent(std::function<void()> event) {")
+		line(3, "throw \"not implemented: PostEvent\";")
+		line(2, "}")
+	}
+	if alltimer != nil {
+		line(2, "virtual void StartTimer(const char *name, std::chrono::milliseconds duration, Event event) {")
+		line(3, "throw \"not implemented: StartTimer\";")
+		line(2, "}")
+		line(2, "virtual void StopTimer(const char *name) {")
+		line(3, "throw \"not implemented: StopTimer\";")
+		line(2, "}")
+	}
 	line(2, "void ProcessEvent(Event event) {")
 	line(3, "(this->*event)();")
 	line(2, "}")
 	line(1, "private:")
+	for _, timer := range alltimer {
+		line(2, "void Fire%s() {", Camel(timer.Name()))
+		line(3, "CurrentState->On%s(this);", Camel(timer.Name()))
+		line(2, "}")
+	}
 	line(2, "struct IState {")
-	for _, ev := range allev {
-		line(3, "virtual void On%s(%s *) {", Camel(ev), name)
+	for _, ev := range allsig {
+		line(3, "virtual bool On%s(%s *%s) {", Camel(ev), name, suffix(root.EventParams(ev), "%[2]s"))
+		line(4, "return false;")
+		line(3, "}")
+	}
+	if len(allreg) != 0 {
+		line(3, "virtual void Exit(%s *) {", name)
+		line(3, "}")
+		line(3, "virtual bool IsFinal() const {")
+		line(4, "return false;")
 		line(3, "}")
 	}
 	line(2, "};")
 	line(2, "struct InvalidState: IState {")
-	for _, ev := range allev {
-		line(3, "void On%s(%s *) override {", Camel(ev), name)
+	for _, ev := range allsig {
+		line(3, "bool On%s(%s *%s) override {", Camel(ev), name, suffix(root.EventParams(ev), "%[2]s"))
 		line(4, "throw \"invalid state\";")
 		line(3, "}")
 	}
 	line(2, "};")
+	var group = func(idt int, events []*Event) {
+		for _, event := range events {
+			if event.HasCond() {
+				line(idt, "if (%s) {", cond(event))
+				transition(idt+1, event)
+				line(idt+1, "return true;")
+				line(idt, "}")
+			} else {
+				transition(idt, event)
+			}
+		}
+		line(idt, "return true;")
+	}
 	for _, state := range root.AllDescendants(root) {
-		if state.IsNested() {
+		if state.IsAtomic() == false {
 			continue
 		}
 		line(2, "struct State%s: IState {", Camel(state.Name()))
-		var groups = state.EventsGrouped()
-		for _, evname := range allev {
-			if events, found := groups[evname]; found {
-				line(3, "void On%s(%s *parent) override {", Camel(evname), name)
-				for _, event := range events {
-					if event.HasCond() {
-						line(4, "if (parent->Cond%s()) {", Camel(event.Cond()))
-						transition(5, event)
-						line(5, "return;")
-						line(4, "}")
-					} else {
-						transition(4, event)
+		var groups = root.Handlers(state)
+		for _, evname := range allsig {
+			if state.IsParallel() && state.Declares(evname) {
+				line(3, "bool On%s(%s *parent%s) override {", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				line(4, "bool handled = false;")
+				var first = true
+				for _, region := range state.Children() {
+					if region.Declares(evname) {
+						if first == false {
+							line(4, "if (parent->%s != this) {", current(state.Region()))
+							line(5, "return true;")
+							line(4, "}")
+						}
+						line(4, "handled = parent->%s->On%s(parent%s) || handled;", current(region), Camel(evname), suffix(root.EventParams(evname), "%[1]s"))
+						first = false
 					}
 				}
+				if events, found := groups[evname]; found {
+					line(4, "if (handled) {")
+					line(5, "return true;")
+					line(4, "}")
+					group(4, events)
+				} else {
+					line(4, "return handled;")
+				}
+				line(3, "}")
+			} else if state.Defers(evname) {
+				line(3, "bool On%s(%s *parent%s) override {", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				line(4, "parent->Deferred.push_back([parent%s]() {", suffix(root.EventParams(evname), "%[1]s"))
+				line(5, "parent->Send%s(%s);", Camel(evname), JoinParams(root.EventParams(evname), "%[1]s"))
+				line(4, "});")
+				line(4, "return true;")
+				line(3, "}")
+			} else if events, found := groups[evname]; found {
+				line(3, "bool On%s(%s *parent%s) override {", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				group(4, events)
+				line(3, "}")
+			}
+		}
+		if region := state.Region(); region.IsRegion() {
+			line(3, "void Exit(%s *parent) override {", name)
+			steps(4, nil, MakeRegionExit(region, state))
+			line(3, "}")
+			if state.IsFinal() {
+				line(3, "bool IsFinal() const override {")
+				line(4, "return true;")
 				line(3, "}")
 			}
 		}
@@ -114,16 +327,76 @@ func PrintCpp(file io.Writer, root *State, source []string) {
 	line(3, "static InvalidState Instance;")
 	line(3, "CurrentState = &Instance;")
 	line(2, "}")
+	if len(allreg) != 0 {
+		line(2, "void SetInvalidState(IState *&state) {")
+		line(3, "static InvalidState Instance;")
+		line(3, "state = &Instance;")
+		line(2, "}")
+	}
 	for _, state := range root.AllDescendants(root) {
-		if state.IsNested() {
+		if state.IsAtomic() == false {
 			continue
 		}
 		line(2, "void SetState%s() {", Camel(state.Name()))
 		line(3, "static State%s Instance;", Camel(state.Name()))
-		line(3, "CurrentState = &Instance;")
+		line(3, "%s = &Instance;", current(state.Region()))
+		if state.IsFinal() && state.Parent() == root {
+			line(3, "Terminated = true;")
+		}
+		for _, history := range allhist {
+			if state.IsDescendantOf(history) {
+				line(3, "History%s = &%s::SetState%s;", Camel(history.Name()), name, Camel(state.Name()))
+			}
+		}
+		line(2, "}")
+	}
+	for _, state := range root.AllDescendants(root) {
+		var events = state.EventsGrouped()["done"]
+		if events == nil {
+			continue
+		}
+		line(2, "static void Complete%s(%s *parent) {", label(state), name)
+		if state.IsParallel() {
+			for _, region := range state.Children() {
+				line(3, "if (parent->%s->IsFinal() == false) {", current(region))
+				line(4, "return;")
+				line(3, "}")
+			}
+		}
+		for _, event := range events {
+			if event.HasCond() {
+				line(3, "if (%s) {", cond(event))
+				transition(4, event)
+				line(4, "return;")
+				line(3, "}")
+			} else {
+				transition(3, event)
+			}
+		}
+		line(2, "}")
+	}
+	if alldefer != nil {
+		line(2, "void Replay() {")
+		line(3, "auto deferred = std::move(Deferred);")
+		line(3, "Deferred.clear();")
+		line(3, "for (auto &send : deferred) {")
+		line(4, "send();")
+		line(3, "}")
 		line(2, "}")
 	}
 	line(2, "IState *CurrentState = nullptr;")
+	for _, region := range allreg {
+		line(2, "IState *%s = nullptr;", current(region))
+	}
+	if root.Finals() != nil {
+		line(2, "bool Terminated = false;")
+	}
+	for _, history := range allhist {
+		line(2, "void (%s::*History%s)() = nullptr;", name, Camel(history.Name()))
+	}
+	if alldefer != nil {
+		line(2, "std::vector<std::function<void()>> Deferred;")
+	}
 	line(1, "};")
 	line(0, "}")
 }
@@ -7,37 +7,150 @@ import (
 )
 
 func PrintCs(file io.Writer, root *State, source []string) {
+	var name, ns = SplitName(root.Name())
+	var allhist = root.AllHistories()
+	var alldefer = root.AllDeferred()
 	var line = func(idt int, format string, args ...interface{}) {
 		fmt.Fprintf(file, strings.Repeat("\t", idt))
 		fmt.Fprintf(file, format, args...)
 		fmt.Fprintf(file, "\r\n")
 	}
-	var transition = func(idt int, event *Event) {
-		var actions, dst = MakeTransition(event)
-		if dst != nil && len(actions) != 0 {
-			line(idt, "parent.CurrentState = InvalidState.Instance;")
+	var args = func(params []Param, event *Event) string {
+		if len(params) == 0 {
+			return ""
+		}
+		return JoinParams(root.EventParams(event.Name()), "%[1]s")
+	}
+	var params = func(params []Param) string {
+		return JoinParams(params, "%[2]s %[1]s")
+	}
+	var suffix = func(params []Param, format string) string {
+		if len(params) == 0 {
+			return ""
 		}
-		for _, act := range actions {
-			line(idt, "parent.Handler.On%s();", Camel(act))
+		return ", " + JoinParams(params, format)
+	}
+	var generic = func(name string, params []Param, extra ...string) string {
+		var types []string
+		for _, param := range params {
+			types = append(types, param.Type)
 		}
-		if dst != nil {
-			line(idt, "parent.CurrentState = State%s.Instance;", Camel(dst.Name()))
+		types = append(types, extra...)
+		if len(types) == 0 {
+			return name
 		}
+		return name + "<" + strings.Join(types, ", ") + ">"
+	}
+	var record = func(idt int, prefix string, dst *State) {
+		for _, history := range allhist {
+			if dst.IsDescendantOf(history) {
+				line(idt, "%sHistory%s = State%s.Instance;", prefix, Camel(history.Name()), Camel(dst.Name()))
+			}
+		}
+	}
+	var current = func(slot *State) string {
+		if slot.IsRegion() {
+			return "Current" + Camel(slot.Name())
+		}
+		return "CurrentState"
+	}
+	var steps = func(idt int, event *Event, steps []Step) {
+		for _, step := range steps {
+			if step.Exit != nil {
+				line(idt, "parent.%s.Exit(parent);", current(step.Exit))
+			} else if step.Start != nil {
+				line(idt, "parent.Handler.StartTimer(\"%s\", TimeSpan.FromMilliseconds(%d), parent.Fire%s);", Camel(step.Start.Name()), step.Start.Timeout().Milliseconds(), Camel(step.Start.Name()))
+			} else if step.Stop != nil {
+				line(idt, "parent.Handler.StopTimer(\"%s\");", Camel(step.Stop.Name()))
+			} else if step.Args != nil {
+				line(idt, "parent.Handler.On%s(%s);", Camel(step.Act), JoinArgs(step.Args))
+			} else {
+				line(idt, "parent.Handler.On%s(%s);", Camel(step.Act), args(root.ActionParams(step.Act), event))
+			}
+		}
+	}
+	var label = func(state *State) string {
+		if state.Parent() == nil {
+			return ""
+		}
+		return Camel(state.Name())
+	}
+	var complete = func(idt int, parent string, dsts []*State) {
+		for _, dst := range dsts {
+			if state := MakeCompletion(dst); state != nil && state.EventsGrouped()["done"] != nil {
+				line(idt, "Complete%s(%s);", label(state), parent)
+			}
+		}
+	}
+	var branch = func(idt int, event *Event, list []Step, dsts []*State) {
+		if len(dsts) != 0 && len(list) != 0 {
+			line(idt, "parent.%s = InvalidState.Instance;", current(event.Src().Region()))
+		}
+		steps(idt, event, list)
+		for _, dst := range dsts {
+			line(idt, "parent.%s = State%s.Instance;", current(dst.Region()), Camel(dst.Name()))
+			record(idt, "parent.", dst)
+		}
+		complete(idt, "parent", dsts)
+		if alldefer != nil && len(dsts) != 0 {
+			line(idt, "parent.Replay();")
+		}
+	}
+	var guard = func(cond *Cond, event *Event) string {
+		return cond.Format(func(name string) string {
+			return "parent.Handler.Cond" + Camel(name) + "(" + args(root.CondParams(name), event) + ")"
+		})
+	}
+	var cond = func(event *Event) string {
+		return guard(event.Cond(), event)
+	}
+	var transition = func(idt int, event *Event) {
+		var branches = MakeBranches(event)
+		if len(branches) == 1 {
+			branch(idt, event, branches[0].Steps, branches[0].Dsts)
+			return
+		}
+		for idx, br := range branches {
+			var keys []string
+			for _, key := range br.Keys {
+				keys = append(keys, fmt.Sprintf("parent.History%s == State%s.Instance", Camel(event.Dst().Name()), Camel(key.Name())))
+			}
+			var test = strings.Join(keys, " || ")
+			if br.Cond != nil {
+				test = guard(br.Cond, event)
+			}
+			switch {
+			case idx == 0:
+				line(idt, "if (%s) {", test)
+			case test == "":
+				line(idt, "} else {")
+			default:
+				line(idt, "} else if (%s) {", test)
+			}
+			branch(idt+1, event, br.Steps, br.Dsts)
+		}
+		line(idt, "}")
 	}
 	var empty = func(events []*Event) bool {
 		for _, event := range events {
-			var actions, dst = MakeTransition(event)
-			if dst != nil || len(actions) != 0 {
-				return false
+			for _, branch := range MakeBranches(event) {
+				if len(branch.Dsts) != 0 || len(branch.Steps) != 0 {
+					return false
+				}
 			}
 		}
 		return true
 	}
-	var name, ns = SplitName(root.Name())
 	var allcond = root.AllConditions()
 	var allact = root.AllActions()
 	var allev = root.AllEvents()
+	var allsig = root.AllSignals()
+	var allreg = root.AllRegions()
+	var alltimer = root.AllTimers()
 	line(0, "using System;")
+	if alldefer != nil {
+		line(0, "using System.Collections.Generic;")
+	}
 	line(0, "")
 	line(0, "/**")
 	line(0, strings.Join(source, "\r\n"))
@@ -47,136 +160,446 @@ func PrintCs(file io.Writer, root *State, source []string) {
 	line(1, "public sealed class %s {", name)
 	line(2, "public interface IHandler {")
 	for _, cond := range allcond {
-		line(3, "bool Cond%s();", Camel(cond))
+		line(3, "bool Cond%s(%s);", Camel(cond), params(root.CondParams(cond)))
 	}
 	for _, act := range allact {
-		line(3, "void On%s();", Camel(act))
+		line(3, "void On%s(%s);", Camel(act), params(root.ActionParams(act)))
 	}
 	line(3, "void PostEvent(Action action);")
+	if alltimer != nil {
+		line(3, "void StartTimer(string name, TimeSpan duration, Action fire);")
+		line(3, "void StopTimer(string name);")
+	}
 	line(2, "}")
 	line(2, "public sealed class DelegateHandler: IHandler {")
 	for _, cond := range allcond {
-		line(3, "public bool Cond%s() {", Camel(cond))
-		line(4, "return cond%s();", Camel(cond))
+		line(3, "public bool Cond%s(%s) {", Camel(cond), params(root.CondParams(cond)))
+		line(4, "return cond%s(%s);", Camel(cond), JoinParams(root.CondParams(cond), "%[1]s"))
 		line(3, "}")
-		line(3, "public Func<bool> cond%s { get; set; }", Camel(cond))
+		line(3, "public %s cond%s { get; set; }", generic("Func", root.CondParams(cond), "bool"), Camel(cond))
 	}
 	for _, act := range allact {
-		line(3, "public void On%s() {", Camel(act))
-		line(4, "on%s();", Camel(act))
+		line(3, "public void On%s(%s) {", Camel(act), params(root.ActionParams(act)))
+		line(4, "on%s(%s);", Camel(act), JoinParams(root.ActionParams(act), "%[1]s"))
 		line(3, "}")
-		line(3, "public Action on%s { get; set; }", Camel(act))
+		line(3, "public %s on%s { get; set; }", generic("Action", root.ActionParams(act)), Camel(act))
 	}
 	line(3, "public void PostEvent(Action action) {")
 	line(4, "postEvent(action);")
 	line(3, "}")
 	line(3, "public Action<Action> postEvent { get; set; }")
+	if alltimer != nil {
+		line(3, "public void StartTimer(string name, TimeSpan duration, Action fire) {")
+		line(4, "startTimer(name, duration, fire);")
+		line(3, "}")
+		line(3, "public Action<string, TimeSpan, Action> startTimer { get; set; }")
+		line(3, "public void StopTimer(string name) {")
+		line(4, "stopTimer(name);")
+		line(3, "}")
+		line(3, "public Action<string> stopTimer { get; set; }")
+	}
 	line(2, "}")
 	for _, ev := range allev {
-		line(2, "public void Send%s() {", Camel(ev))
-		line(3, "CurrentState.On%s(this);", Camel(ev))
+		line(2, "public void Send%s(%s) {", Camel(ev), params(root.EventParams(ev)))
+		line(3, "if (!CurrentState.On%s(this%s)) {", Camel(ev), suffix(root.EventParams(ev), "%[1]s"))
+		line(4, "OnUnhandled?.Invoke(\"%s\");", Camel(ev))
+		line(3, "}")
 		line(2, "}")
 	}
 	for _, ev := range allev {
-		line(2, "public void Post%s() {", Camel(ev))
-		line(3, "Handler.PostEvent(Send%s);", Camel(ev))
+		line(2, "public void Post%s(%s) {", Camel(ev), params(root.EventParams(ev)))
+		if len(root.EventParams(ev)) == 0 {
+			line(3, "Handler.PostEvent(Send%s);", Camel(ev))
+		} else {
+			line(3, "Handler.PostEvent(() => Send%s(%s));", Camel(ev), JoinParams(root.EventParams(ev), "%[1]s"))
+		}
+		line(2, "}")
+	}
+	for _, timer := range alltimer {
+		line(2, "private void Fire%s() {", Camel(timer.Name()))
+		line(3, "CurrentState.On%s(this);", Camel(timer.Name()))
 		line(2, "}")
 	}
 	line(2, "public void Start() {")
 	line(3, "if (CurrentState == null) {")
-	var actions, dst = MakeStart(root)
-	for _, act := range actions {
-		line(4, "Handler.On%s();", Camel(act))
+	var actions, dsts = MakeStart(root)
+	for _, step := range actions {
+		if step.Start != nil {
+			line(4, "Handler.StartTimer(\"%s\", TimeSpan.FromMilliseconds(%d), Fire%s);", Camel(step.Start.Name()), step.Start.Timeout().Milliseconds(), Camel(step.Start.Name()))
+		} else {
+			line(4, "Handler.On%s();", Camel(step.Act))
+		}
+	}
+	for _, dst := range dsts {
+		line(4, "%s = State%s.Instance;", current(dst.Region()), Camel(dst.Name()))
+		record(4, "", dst)
 	}
-	line(4, "CurrentState = State%s.Instance;", Camel(dst.Name()))
+	complete(4, "this", dsts)
 	line(3, "}")
 	line(2, "}")
+	if finals := root.Finals(); finals != nil {
+		var list []string
+		for _, final := range finals {
+			list = append(list, fmt.Sprintf("CurrentState == State%s.Instance", Camel(final.Name())))
+		}
+		line(2, "public bool IsTerminated() {")
+		line(3, "return %s;", strings.Join(list, " || "))
+		line(2, "}")
+	}
 	line(2, "private class IState {")
-	for _, ev := range allev {
-		line(3, "public virtual void On%s(%s parent) {", Camel(ev), name)
+	for _, ev := range allsig {
+		line(3, "public virtual bool On%s(%s parent%s) {", Camel(ev), name, suffix(root.EventParams(ev), "%[2]s %[1]s"))
+		line(4, "return false;")
+		line(3, "}")
+	}
+	if len(allreg) != 0 {
+		line(3, "public virtual void Exit(%s parent) {", name)
 		line(3, "}")
 	}
 	line(2, "}")
 	line(2, "private class InvalidState: IState {")
-	for _, ev := range allev {
-		line(3, "public override void On%s(%s parent) {", Camel(ev), name)
+	for _, ev := range allsig {
+		line(3, "public override bool On%s(%s parent%s) {", Camel(ev), name, suffix(root.EventParams(ev), "%[2]s %[1]s"))
 		line(4, "throw new Exception();")
 		line(3, "}")
 	}
 	line(3, "public static readonly IState Instance = new InvalidState();")
 	line(2, "}")
+	var group = func(idt int, events []*Event) {
+		if empty(events) {
+			line(idt, "return true;")
+			return
+		}
+		for _, event := range events {
+			if event.HasCond() {
+				line(idt, "if (%s) {", cond(event))
+				transition(idt+1, event)
+				line(idt+1, "return true;")
+				line(idt, "}")
+			} else {
+				transition(idt, event)
+			}
+		}
+		line(idt, "return true;")
+	}
 	for _, state := range root.AllDescendants(root) {
-		if state.IsNested() {
+		if state.IsAtomic() == false {
 			continue
 		}
 		line(2, "private class State%s: IState {", Camel(state.Name()))
-		var groups = state.EventsGrouped()
-		for _, evname := range allev {
-			if events, found := groups[evname]; found {
-				if empty(events) {
-					continue
-				}
-				line(3, "public override void On%s(%s parent) {", Camel(evname), name)
-				for _, event := range events {
-					if event.HasCond() {
-						line(4, "if (parent.Handler.Cond%s()) {", Camel(event.Cond()))
-						transition(5, event)
-						line(5, "return;")
-						line(4, "}")
-					} else {
-						transition(4, event)
+		var groups = root.Handlers(state)
+		for _, evname := range allsig {
+			if state.IsParallel() && state.Declares(evname) {
+				line(3, "public override bool On%s(%s parent%s) {", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				line(4, "var handled = false;")
+				var first = true
+				for _, region := range state.Children() {
+					if region.Declares(evname) {
+						if first == false {
+							line(4, "if (parent.%s != this) {", current(state.Region()))
+							line(5, "return true;")
+							line(4, "}")
+						}
+						line(4, "handled = parent.%s.On%s(parent%s) || handled;", current(region), Camel(evname), suffix(root.EventParams(evname), "%[1]s"))
+						first = false
 					}
 				}
+				if events, found := groups[evname]; found {
+					line(4, "if (handled) {")
+					line(5, "return true;")
+					line(4, "}")
+					group(4, events)
+				} else {
+					line(4, "return handled;")
+				}
+				line(3, "}")
+			} else if state.Defers(evname) {
+				line(3, "public override bool On%s(%s parent%s) {", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				line(4, "parent.Deferred.Add(() => parent.Send%s(%s));", Camel(evname), JoinParams(root.EventParams(evname), "%[1]s"))
+				line(4, "return true;")
+				line(3, "}")
+			} else if events, found := groups[evname]; found {
+				line(3, "public override bool On%s(%s parent%s) {", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				group(4, events)
 				line(3, "}")
 			}
 		}
+		if region := state.Region(); region.IsRegion() {
+			line(3, "public override void Exit(%s parent) {", name)
+			steps(4, nil, MakeRegionExit(region, state))
+			line(3, "}")
+		}
 		line(3, "public static readonly IState Instance = new State%s();", Camel(state.Name()))
 		line(2, "}")
 	}
+	for _, state := range root.AllDescendants(root) {
+		var events = state.EventsGrouped()["done"]
+		if events == nil {
+			continue
+		}
+		line(2, "private static void Complete%s(%s parent) {", label(state), name)
+		if state.IsParallel() {
+			for _, region := range state.Children() {
+				var list []string
+				for _, final := range region.Finals() {
+					list = append(list, fmt.Sprintf("parent.%s != State%s.Instance", current(region), Camel(final.Name())))
+				}
+				line(3, "if (%s) {", strings.Join(list, " && "))
+				line(4, "return;")
+				line(3, "}")
+			}
+		}
+		for _, event := range events {
+			if event.HasCond() {
+				line(3, "if (%s) {", cond(event))
+				transition(4, event)
+				line(4, "return;")
+				line(3, "}")
+			} else {
+				transition(3, event)
+			}
+		}
+		line(2, "}")
+	}
 	line(2, "public %s(IHandler handler) {", name)
 	line(3, "Handler = handler;")
 	line(2, "}")
+	line(2, "public Action<string> OnUnhandled { get; set; }")
 	line(2, "private readonly IHandler Handler;")
 	line(2, "private IState CurrentState;")
+	for _, region := range allreg {
+		line(2, "private IState %s;", current(region))
+	}
+	for _, history := range allhist {
+		line(2, "private IState History%s;", Camel(history.Name()))
+	}
+	if alldefer != nil {
+		line(2, "private List<Action> Deferred = new List<Action>();")
+		line(2, "private void Replay() {")
+		line(3, "var deferred = Deferred;")
+		line(3, "Deferred = new List<Action>();")
+		line(3, "foreach (var send in deferred) {")
+		line(4, "send();")
+		line(3, "}")
+		line(2, "}")
+	}
 	line(1, "}")
 	line(0, "}")
 }
 
 func PrintLmsCs(file io.Writer, root *State, source []string) {
+	var name, ns = SplitName(root.Name())
+	var allhist = root.AllHistories()
+	var alldefer = root.AllDeferred()
 	var line = func(idt int, format string, args ...interface{}) {
 		fmt.Fprintf(file, strings.Repeat("  ", idt))
 		fmt.Fprintf(file, format, args...)
 		fmt.Fprintf(file, "\r\n")
 	}
-	var transition = func(idt int, event *Event) {
-		var actions, dst = MakeTransition(event)
-		if dst != nil && len(actions) != 0 {
-			line(idt, "parent.Handler.Log(\"invalid state\");")
-			line(idt, "parent.CurrentState = InvalidState.Instance;")
+	var args = func(params []Param, event *Event) string {
+		if len(params) == 0 {
+			return ""
+		}
+		return JoinParams(root.EventParams(event.Name()), "%[1]s")
+	}
+	var params = func(params []Param) string {
+		return JoinParams(params, "%[2]s %[1]s")
+	}
+	var suffix = func(params []Param, format string) string {
+		if len(params) == 0 {
+			return ""
+		}
+		return ", " + JoinParams(params, format)
+	}
+	var generic = func(name string, params []Param, extra ...string) string {
+		var types []string
+		for _, param := range params {
+			types = append(types, param.Type)
+		}
+		types = append(types, extra...)
+		if len(types) == 0 {
+			return name
+		}
+		return name + "<" + strings.Join(types, ", ") + ">"
+	}
+	var record = func(idt int, prefix string, dst *State) {
+		for _, history := range allhist {
+			if dst.IsDescendantOf(history) {
+				line(idt, "%sHistory%s = State%s.Instance;", prefix, Camel(history.Name()), Camel(dst.Name()))
+			}
+		}
+	}
+	var current = func(slot *State) string {
+		if slot.IsRegion() {
+			return "Current" + Camel(slot.Name())
+		}
+		return "CurrentState"
+	}
+	var steps = func(idt int, event *Event, steps []Step) {
+		for _, step := range steps {
+			if step.Exit != nil {
+				line(idt, "parent.Handler.Log(\"exit %s\");", Camel(step.Exit.Name()))
+				line(idt, "parent.%s.Exit(parent);", current(step.Exit))
+			} else if step.Start != nil {
+				line(idt, "parent.Handler.Log(\"start timer %s\");", Camel(step.Start.Name()))
+				line(idt, "parent.Handler.StartTimer(\"%s\", TimeSpan.FromMilliseconds(%d), parent.Fire%s);", Camel(step.Start.Name()), step.Start.Timeout().Milliseconds(), Camel(step.Start.Name()))
+			} else if step.Stop != nil {
+				line(idt, "parent.Handler.Log(\"stop timer %s\");", Camel(step.Stop.Name()))
+				line(idt, "parent.Handler.StopTimer(\"%s\");", Camel(step.Stop.Name()))
+			} else {
+				line(idt, "parent.Handler.Log(\"action %s\");", Camel(step.Act))
+				if step.Args != nil {
+					line(idt, "parent.Handler.On%s(%s);", Camel(step.Act), JoinArgs(step.Args))
+				} else {
+					line(idt, "parent.Handler.On%s(%s);", Camel(step.Act), args(root.ActionParams(step.Act), event))
+				}
+			}
+		}
+	}
+	var label = func(state *State) string {
+		if state.Parent() == nil {
+			return ""
 		}
-		for _, act := range actions {
-			line(idt, "parent.Handler.Log(\"action %s\");", Camel(act))
-			line(idt, "parent.Handler.On%s();", Camel(act))
+		return Camel(state.Name())
+	}
+	var complete = func(idt int, parent string, dsts []*State) {
+		for _, dst := range dsts {
+			if state := MakeCompletion(dst); state != nil && state.EventsGrouped()["done"] != nil {
+				line(idt, "Complete%s(%s);", label(state), parent)
+			}
 		}
-		if dst != nil {
+	}
+	var branch = func(idt int, event *Event, list []Step, dsts []*State) {
+		if len(dsts) != 0 && len(list) != 0 {
+			line(idt, "parent.Handler.Log(\"invalid state\");")
+			line(idt, "parent.%s = InvalidState.Instance;", current(event.Src().Region()))
+		}
+		steps(idt, event, list)
+		for _, dst := range dsts {
 			line(idt, "parent.Handler.Log(\"state %s\");", Camel(dst.Name()))
-			line(idt, "parent.CurrentState = State%s.Instance;", Camel(dst.Name()))
+			line(idt, "parent.%s = State%s.Instance;", current(dst.Region()), Camel(dst.Name()))
+			record(idt, "parent.", dst)
+		}
+		complete(idt, "parent", dsts)
+		if alldefer != nil && len(dsts) != 0 {
+			line(idt, "parent.Replay();")
 		}
 	}
+	var listing = func(list []Step) string {
+		var names []string
+		for _, step := range list {
+			if step.Exit != nil {
+				names = append(names, "exit "+step.Exit.Name())
+			} else if step.Start != nil {
+				names = append(names, "start "+step.Start.Name())
+			} else if step.Stop != nil {
+				names = append(names, "stop "+step.Stop.Name())
+			} else {
+				names = append(names, step.Act)
+			}
+		}
+		return "[" + strings.Join(names, " ") + "]"
+	}
+	var expand func(list []Step, config []*State) string
+	expand = func(list []Step, config []*State) string {
+		var message = ""
+		for _, step := range list {
+			if step.Act != "" {
+				message += "<" + Camel(step.Act) + ">"
+			}
+			if step.Exit == nil {
+				continue
+			}
+			for _, state := range config {
+				if state.Region() == step.Exit {
+					message += expand(MakeRegionExit(step.Exit, state), config)
+				}
+			}
+		}
+		return message
+	}
+	var completes = func(dsts []*State) bool {
+		for _, dst := range dsts {
+			if state := MakeCompletion(dst); state != nil && state.EventsGrouped()["done"] != nil {
+				return true
+			}
+		}
+		return false
+	}
+	var shared = func(state *State, evname string) bool {
+		for child, parent := state, state.Parent(); parent != nil; child, parent = parent, parent.Parent() {
+			if parent.IsParallel() {
+				for _, region := range parent.Children() {
+					if region != child && region.Declares(evname) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	var guard = func(cond *Cond, event *Event) string {
+		return cond.Format(func(name string) string {
+			return "parent.Handler.Cond" + Camel(name) + "(" + args(root.CondParams(name), event) + ")"
+		})
+	}
+	var cond = func(event *Event) string {
+		return guard(event.Cond(), event)
+	}
+	var transition = func(idt int, event *Event) {
+		var branches = MakeBranches(event)
+		if len(branches) == 1 {
+			branch(idt, event, branches[0].Steps, branches[0].Dsts)
+			return
+		}
+		for idx, br := range branches {
+			var keys []string
+			for _, key := range br.Keys {
+				keys = append(keys, fmt.Sprintf("parent.History%s == State%s.Instance", Camel(event.Dst().Name()), Camel(key.Name())))
+			}
+			var test = strings.Join(keys, " || ")
+			if br.Cond != nil {
+				test = guard(br.Cond, event)
+			}
+			switch {
+			case idx == 0:
+				line(idt, "if (%s) {", test)
+			case test == "":
+				line(idt, "} else {")
+			default:
+				line(idt, "} else if (%s) {", test)
+			}
+			branch(idt+1, event, br.Steps, br.Dsts)
+		}
+		line(idt, "}")
+	}
 	var empty = func(events []*Event) bool {
 		for _, event := range events {
-			var actions, dst = MakeTransition(event)
-			if dst != nil || len(actions) != 0 {
-				return false
+			for _, branch := range MakeBranches(event) {
+				if len(branch.Dsts) != 0 || len(branch.Steps) != 0 {
+					return false
+				}
 			}
 		}
 		return true
 	}
-	var name, ns = SplitName(root.Name())
 	var allcond = root.AllConditions()
 	var allact = root.AllActions()
 	var allev = root.AllEvents()
+	var allsig = root.AllSignals()
+	var allreg = root.AllRegions()
+	var alltimer = root.AllTimers()
+	var names = func(dsts []*State) string {
+		var list []string
+		for _, dst := range dsts {
+			list = append(list, dst.Name())
+		}
+		return strings.Join(list, ", ")
+	}
 	line(0, "using System;")
+	if alldefer != nil {
+		line(0, "using System.Collections.Generic;")
+	}
 	line(0, "")
 	line(0, "// https://stswiki.net.plm.eds.com/display/SAN/State+machine+compiler")
 	line(0, "")
@@ -185,21 +608,24 @@ func PrintLmsCs(file io.Writer, root *State, source []string) {
 	line(0, "**/")
 	line(0, "")
 	line(0, "/*")
-	var actions, dst = MakeStart(root)
-	line(0, "start %s %s", dst.Name(), actions)
+	var actions, dsts = MakeStart(root)
+	line(0, "start %s %s", names(dsts), listing(actions))
 	for _, state := range root.AllDescendants(root) {
-		if state.IsNested() {
+		if state.IsAtomic() == false {
 			continue
 		}
 		line(0, "%s", state.Name())
-		var groups = state.EventsGrouped()
-		for _, evname := range allev {
+		var groups = root.Handlers(state)
+		for _, evname := range allsig {
+			if state.Defers(evname) {
+				line(1, "%s defer", evname)
+			}
 			if events, found := groups[evname]; found {
 				if empty(events) {
 					continue
 				}
 				for _, event := range events {
-					var actions, dst = MakeTransition(event)
+					var steps, dsts = MakeTransition(event)
 					var (
 						condstr = ""
 						dststr  = ""
@@ -207,10 +633,13 @@ func PrintLmsCs(file io.Writer, root *State, source []string) {
 					if event.HasCond() {
 						condstr = fmt.Sprintf(" if %s", event.Cond())
 					}
-					if dst != nil {
-						dststr = fmt.Sprintf(" dst %s", dst.Name())
+					if event.IsElse() {
+						condstr = " else"
 					}
-					line(1, "%s%s%s %s", evname, condstr, dststr, actions)
+					if len(dsts) != 0 {
+						dststr = fmt.Sprintf(" dst %s", names(dsts))
+					}
+					line(1, "%s%s%s %s", evname, condstr, dststr, listing(steps))
 				}
 			}
 		}
@@ -224,36 +653,52 @@ func PrintLmsCs(file io.Writer, root *State, source []string) {
 	line(2, "public interface IHandler")
 	line(2, "{")
 	for _, cond := range allcond {
-		line(3, "bool Cond%s();", Camel(cond))
+		line(3, "bool Cond%s(%s);", Camel(cond), params(root.CondParams(cond)))
 	}
 	for _, act := range allact {
-		line(3, "void On%s();", Camel(act))
+		line(3, "void On%s(%s);", Camel(act), params(root.ActionParams(act)))
 	}
 	line(3, "void PostEvent(Action action);")
+	if alltimer != nil {
+		line(3, "void StartTimer(string name, TimeSpan duration, Action fire);")
+		line(3, "void StopTimer(string name);")
+	}
 	line(3, "void Log(string message);")
 	line(2, "}")
 	line(2, "")
 	line(2, "public sealed class DelegateHandler: IHandler")
 	line(2, "{")
 	for _, cond := range allcond {
-		line(3, "public bool Cond%s()", Camel(cond))
+		line(3, "public bool Cond%s(%s)", Camel(cond), params(root.CondParams(cond)))
 		line(3, "{")
-		line(4, "return cond%s();", Camel(cond))
+		line(4, "return cond%s(%s);", Camel(cond), JoinParams(root.CondParams(cond), "%[1]s"))
 		line(3, "}")
-		line(3, "public Func<bool> cond%s { get; set; }", Camel(cond))
+		line(3, "public %s cond%s { get; set; }", generic("Func", root.CondParams(cond), "bool"), Camel(cond))
 	}
 	for _, act := range allact {
-		line(3, "public void On%s()", Camel(act))
+		line(3, "public void On%s(%s)", Camel(act), params(root.ActionParams(act)))
 		line(3, "{")
-		line(4, "on%s();", Camel(act))
+		line(4, "on%s(%s);", Camel(act), JoinParams(root.ActionParams(act), "%[1]s"))
 		line(3, "}")
-		line(3, "public Action on%s { get; set; }", Camel(act))
+		line(3, "public %s on%s { get; set; }", generic("Action", root.ActionParams(act)), Camel(act))
 	}
 	line(3, "public void PostEvent(Action action)")
 	line(3, "{")
 	line(4, "postEvent(action);")
 	line(3, "}")
 	line(3, "public Action<Action> postEvent { get; set; }")
+	if alltimer != nil {
+		line(3, "public void StartTimer(string name, TimeSpan duration, Action fire)")
+		line(3, "{")
+		line(4, "startTimer(name, duration, fire);")
+		line(3, "}")
+		line(3, "public Action<string, TimeSpan, Action> startTimer { get; set; }")
+		line(3, "public void StopTimer(string name)")
+		line(3, "{")
+		line(4, "stopTimer(name);")
+		line(3, "}")
+		line(3, "public Action<string> stopTimer { get; set; }")
+	}
 	line(3, "public void Log(string message)")
 	line(3, "{")
 	line(4, "logger?.Invoke(message);")
@@ -262,17 +707,32 @@ func PrintLmsCs(file io.Writer, root *State, source []string) {
 	line(2, "}")
 	line(2, "")
 	for _, ev := range allev {
-		line(2, "public void Send%s()", Camel(ev))
+		line(2, "public void Send%s(%s)", Camel(ev), params(root.EventParams(ev)))
 		line(2, "{")
 		line(3, "Handler.Log(\"send %s\");", Camel(ev))
-		line(3, "CurrentState.On%s(this);", Camel(ev))
+		line(3, "if (!CurrentState.On%s(this%s))", Camel(ev), suffix(root.EventParams(ev), "%[1]s"))
+		line(3, "{")
+		line(4, "Handler.Log(\"ignored %s\");", Camel(ev))
+		line(4, "OnUnhandled?.Invoke(\"%s\");", Camel(ev))
+		line(3, "}")
 		line(2, "}")
 	}
 	for _, ev := range allev {
-		line(2, "public void Post%s()", Camel(ev))
+		line(2, "public void Post%s(%s)", Camel(ev), params(root.EventParams(ev)))
 		line(2, "{")
 		line(3, "Handler.Log(\"post %s\");", Camel(ev))
-		line(3, "Handler.PostEvent(Send%s);", Camel(ev))
+		if len(root.EventParams(ev)) == 0 {
+			line(3, "Handler.PostEvent(Send%s);", Camel(ev))
+		} else {
+			line(3, "Handler.PostEvent(() => Send%s(%s));", Camel(ev), JoinParams(root.EventParams(ev), "%[1]s"))
+		}
+		line(2, "}")
+	}
+	for _, timer := range alltimer {
+		line(2, "private void Fire%s()", Camel(timer.Name()))
+		line(2, "{")
+		line(3, "Handler.Log(\"fire %s\");", Camel(timer.Name()))
+		line(3, "CurrentState.On%s(this);", Camel(timer.Name()))
 		line(2, "}")
 	}
 	line(2, "")
@@ -281,31 +741,56 @@ func PrintLmsCs(file io.Writer, root *State, source []string) {
 	line(3, "if (CurrentState == null)")
 	line(3, "{")
 	line(4, "Handler.Log(\"start *\");")
-	actions, dst = MakeStart(root)
-	for _, act := range actions {
-		line(4, "Handler.Log(\"action %s\");", Camel(act))
-		line(4, "Handler.On%s();", Camel(act))
+	actions, dsts = MakeStart(root)
+	for _, step := range actions {
+		if step.Start != nil {
+			line(4, "Handler.Log(\"start timer %s\");", Camel(step.Start.Name()))
+			line(4, "Handler.StartTimer(\"%s\", TimeSpan.FromMilliseconds(%d), Fire%s);", Camel(step.Start.Name()), step.Start.Timeout().Milliseconds(), Camel(step.Start.Name()))
+		} else {
+			line(4, "Handler.Log(\"action %s\");", Camel(step.Act))
+			line(4, "Handler.On%s();", Camel(step.Act))
+		}
 	}
-	line(4, "Handler.Log(\"state %s\");", Camel(dst.Name()))
-	line(4, "CurrentState = State%s.Instance;", Camel(dst.Name()))
+	for _, dst := range dsts {
+		line(4, "Handler.Log(\"state %s\");", Camel(dst.Name()))
+		line(4, "%s = State%s.Instance;", current(dst.Region()), Camel(dst.Name()))
+		record(4, "", dst)
+	}
+	complete(4, "this", dsts)
 	line(3, "}")
 	line(2, "}")
 	line(2, "")
+	if finals := root.Finals(); finals != nil {
+		var list []string
+		for _, final := range finals {
+			list = append(list, fmt.Sprintf("CurrentState == State%s.Instance", Camel(final.Name())))
+		}
+		line(2, "public bool IsTerminated()")
+		line(2, "{")
+		line(3, "return %s;", strings.Join(list, " || "))
+		line(2, "}")
+		line(2, "")
+	}
 
 	line(2, "private class IState")
 	line(2, "{")
-	for _, ev := range allev {
-		line(3, "public virtual void On%s(%s parent)", Camel(ev), name)
+	for _, ev := range allsig {
+		line(3, "public virtual bool On%s(%s parent%s)", Camel(ev), name, suffix(root.EventParams(ev), "%[2]s %[1]s"))
+		line(3, "{")
+		line(4, "return false;")
+		line(3, "}")
+	}
+	if len(allreg) != 0 {
+		line(3, "public virtual void Exit(%s parent)", name)
 		line(3, "{")
-		line(4, "parent.Handler.Log(\"ignored %s\");", Camel(ev))
 		line(3, "}")
 	}
 	line(2, "}")
 	line(2, "")
 	line(2, "private class InvalidState: IState")
 	line(2, "{")
-	for _, ev := range allev {
-		line(3, "public override void On%s(%s parent)", Camel(ev), name)
+	for _, ev := range allsig {
+		line(3, "public override bool On%s(%s parent%s)", Camel(ev), name, suffix(root.EventParams(ev), "%[2]s %[1]s"))
 		line(3, "{")
 		line(4, "parent.Handler.Log(\"invalid event %s\");", Camel(ev))
 		line(4, "throw new InvalidOperationException();")
@@ -314,52 +799,153 @@ func PrintLmsCs(file io.Writer, root *State, source []string) {
 	line(3, "public static readonly IState Instance = new InvalidState();")
 	line(2, "}")
 	line(2, "")
+	var group = func(idt int, state *State, evname string, events []*Event) {
+		if empty(events) {
+			line(idt, "parent.Handler.Log(\"ignored %s\");", Camel(evname))
+			line(idt, "return true;")
+			return
+		}
+		for _, event := range events {
+			if event.HasCond() {
+				line(idt, "if (%s)", cond(event))
+				line(idt, "{")
+				line(idt+1, "parent.Handler.Log(\"current state %s *\");", Camel(state.Name()))
+				line(idt+1, "parent.Handler.Log(\"event %s\");", Camel(evname))
+				line(idt+1, "parent.Handler.Log(\"condition %s\");", event.Cond().Format(Camel))
+				transition(idt+1, event)
+				line(idt+1, "parent.Handler.Log(\"done\");")
+				line(idt+1, "return true;")
+				line(idt, "}")
+			} else {
+				line(idt, "parent.Handler.Log(\"current state %s *\");", Camel(state.Name()))
+				line(idt, "parent.Handler.Log(\"event %s\");", Camel(evname))
+				transition(idt, event)
+				line(idt, "parent.Handler.Log(\"done\");")
+			}
+		}
+		line(idt, "return true;")
+	}
 	for _, state := range root.AllDescendants(root) {
-		if state.IsNested() {
+		if state.IsAtomic() == false {
 			continue
 		}
 		line(2, "private class State%s: IState", Camel(state.Name()))
 		line(2, "{")
-		var groups = state.EventsGrouped()
-		for _, evname := range allev {
-			if events, found := groups[evname]; found {
-				if empty(events) {
-					continue
-				}
-				line(3, "public override void On%s(%s parent)", Camel(evname), name)
+		var groups = root.Handlers(state)
+		for _, evname := range allsig {
+			if state.IsParallel() && state.Declares(evname) {
+				line(3, "public override bool On%s(%s parent%s)", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
 				line(3, "{")
-				for _, event := range events {
-					if event.HasCond() {
-						line(4, "if (parent.Handler.Cond%s())", Camel(event.Cond()))
-						line(4, "{")
-						line(5, "parent.Handler.Log(\"current state %s *\");", Camel(state.Name()))
-						line(5, "parent.Handler.Log(\"event %s\");", Camel(evname))
-						line(5, "parent.Handler.Log(\"condition %s\");", Camel(event.Cond()))
-						transition(5, event)
-						line(5, "parent.Handler.Log(\"done\");")
-						line(5, "return;")
-						line(4, "}")
-					} else {
-						line(4, "parent.Handler.Log(\"current state %s *\");", Camel(state.Name()))
-						line(4, "parent.Handler.Log(\"event %s\");", Camel(evname))
-						transition(4, event)
-						line(4, "parent.Handler.Log(\"done\");")
+				line(4, "var handled = false;")
+				var first = true
+				for _, region := range state.Children() {
+					if region.Declares(evname) {
+						if first == false {
+							line(4, "if (parent.%s != this)", current(state.Region()))
+							line(4, "{")
+							line(5, "return true;")
+							line(4, "}")
+						}
+						line(4, "handled = parent.%s.On%s(parent%s) || handled;", current(region), Camel(evname), suffix(root.EventParams(evname), "%[1]s"))
+						first = false
 					}
 				}
+				if events, found := groups[evname]; found {
+					line(4, "if (handled)")
+					line(4, "{")
+					line(5, "return true;")
+					line(4, "}")
+					group(4, state, evname, events)
+				} else {
+					line(4, "return handled;")
+				}
+				line(3, "}")
+			} else if state.Defers(evname) {
+				line(3, "public override bool On%s(%s parent%s)", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				line(3, "{")
+				line(4, "parent.Handler.Log(\"defer %s\");", Camel(evname))
+				line(4, "parent.Deferred.Add(() => parent.Send%s(%s));", Camel(evname), JoinParams(root.EventParams(evname), "%[1]s"))
+				line(4, "return true;")
+				line(3, "}")
+			} else if events, found := groups[evname]; found {
+				line(3, "public override bool On%s(%s parent%s)", Camel(evname), name, suffix(root.EventParams(evname), "%[2]s %[1]s"))
+				line(3, "{")
+				group(4, state, evname, events)
 				line(3, "}")
 			}
 		}
+		if region := state.Region(); region.IsRegion() {
+			line(3, "public override void Exit(%s parent)", name)
+			line(3, "{")
+			steps(4, nil, MakeRegionExit(region, state))
+			line(3, "}")
+		}
 		line(3, "public static readonly IState Instance = new State%s();", Camel(state.Name()))
 		line(2, "}")
 		line(2, "")
 	}
+	for _, state := range root.AllDescendants(root) {
+		var events = state.EventsGrouped()["done"]
+		if events == nil {
+			continue
+		}
+		line(2, "private static void Complete%s(%s parent)", label(state), name)
+		line(2, "{")
+		if state.IsParallel() {
+			for _, region := range state.Children() {
+				var list []string
+				for _, final := range region.Finals() {
+					list = append(list, fmt.Sprintf("parent.%s != State%s.Instance", current(region), Camel(final.Name())))
+				}
+				line(3, "if (%s)", strings.Join(list, " && "))
+				line(3, "{")
+				line(4, "return;")
+				line(3, "}")
+			}
+		}
+		line(3, "parent.Handler.Log(\"complete %s\");", Camel(state.Name()))
+		for _, event := range events {
+			if event.HasCond() {
+				line(3, "if (%s)", cond(event))
+				line(3, "{")
+				line(4, "parent.Handler.Log(\"condition %s\");", event.Cond().Format(Camel))
+				transition(4, event)
+				line(4, "return;")
+				line(3, "}")
+			} else {
+				transition(3, event)
+			}
+		}
+		line(2, "}")
+		line(2, "")
+	}
 	line(2, "public %s(IHandler handler)", name)
 	line(2, "{")
 	line(3, "Handler = handler;")
 	line(2, "}")
 	line(2, "")
+	line(2, "public Action<string> OnUnhandled { get; set; }")
 	line(2, "private readonly IHandler Handler;")
 	line(2, "private IState CurrentState;")
+	for _, region := range allreg {
+		line(2, "private IState %s;", current(region))
+	}
+	for _, history := range allhist {
+		line(2, "private IState History%s;", Camel(history.Name()))
+	}
+	if alldefer != nil {
+		line(2, "private List<Action> Deferred = new List<Action>();")
+		line(2, "")
+		line(2, "private void Replay()")
+		line(2, "{")
+		line(3, "var deferred = Deferred;")
+		line(3, "Deferred = new List<Action>();")
+		line(3, "foreach (var send in deferred)")
+		line(3, "{")
+		line(4, "send();")
+		line(3, "}")
+		line(2, "}")
+	}
 	line(2, "")
 	line(2, "public static void Test(Action<bool> assert)")
 	line(2, "{")
@@ -367,39 +953,74 @@ func PrintLmsCs(file io.Writer, root *State, source []string) {
 	line(3, "var handler = new DelegateHandler()")
 	line(3, "{")
 	for _, cond := range allcond {
-		line(4, "cond%s = () => false,", Camel(cond))
+		line(4, "cond%s = (%s) => false,", Camel(cond), JoinParams(root.CondParams(cond), "%[1]s"))
 	}
 	for _, act := range allact {
-		line(4, "on%s = () => result += \"<%s>\",", Camel(act), Camel(act))
+		line(4, "on%s = (%s) => result += \"<%s>\",", Camel(act), JoinParams(root.ActionParams(act), "%[1]s"), Camel(act))
+	}
+	if alltimer != nil {
+		line(4, "startTimer = (name, duration, fire) => {},")
+		line(4, "stopTimer = (name) => {},")
 	}
 	line(3, "};")
 	line(3, "var test = new %s(handler);", name)
 	for _, state := range root.AllDescendants(root) {
-		if state.IsNested() {
+		if state.IsAtomic() == false {
 			continue
 		}
-		var groups = state.EventsGrouped()
+		var _, config = MakeEntry(state.Path())
+		var groups = root.Handlers(state)
 		for _, evname := range allev {
+			if state.IsParallel() && state.Declares(evname) || shared(state, evname) {
+				continue
+			}
 			if events, found := groups[evname]; found {
+				var prior []*Cond
 				for _, event := range events {
-					var actions, dst = MakeTransition(event)
-					var message = ""
-					for _, act := range actions {
-						message += "<" + Camel(act) + ">"
-					}
-					line(3, "test.CurrentState = State%s.Instance;", Camel(state.Name()))
+					var values, ok = Satisfy(event.Cond(), prior)
 					if event.HasCond() {
-						line(3, "handler.cond%s = () => true;", Camel(event.Cond()))
+						prior = append(prior, event.Cond())
+					}
+					if ok == false {
+						continue
+					}
+					var conds []string
+					for cond, value := range values {
+						if value {
+							conds = append(conds, cond)
+						}
 					}
-					line(3, "test.Send%s();", Camel(evname))
+					conds = StringSet(conds)
+					var steps, dsts = MakeTransition(event)
+					for _, br := range MakeBranches(event) {
+						if br.Keys == nil && br.Cond != nil && br.Cond.Eval(values) {
+							steps, dsts = br.Steps, br.Dsts
+							break
+						}
+					}
+					if completes(dsts) {
+						continue
+					}
+					var message = expand(steps, config)
+					for _, st := range config {
+						line(3, "test.%s = State%s.Instance;", current(st.Region()), Camel(st.Name()))
+					}
+					for _, history := range allhist {
+						line(3, "test.History%s = null;", Camel(history.Name()))
+					}
+					for _, cond := range conds {
+						line(3, "handler.cond%s = (%s) => true;", Camel(cond), JoinParams(root.CondParams(cond), "%[1]s"))
+					}
+					line(3, "test.Send%s(%s);", Camel(evname), JoinParams(root.EventParams(evname), "default(%[2]s)"))
 					line(3, "assert(result == \"%s\");", message)
-					if dst != nil {
-						line(3, "assert(test.CurrentState == State%s.Instance);", Camel(dst.Name()))
-					} else {
-						line(3, "assert(test.CurrentState == State%s.Instance);", Camel(state.Name()))
+					for _, dst := range dsts {
+						line(3, "assert(test.%s == State%s.Instance);", current(dst.Region()), Camel(dst.Name()))
 					}
-					if event.HasCond() {
-						line(3, "handler.cond%s = () => false;", Camel(event.Cond()))
+					if len(dsts) == 0 {
+						line(3, "assert(test.%s == State%s.Instance);", current(state.Region()), Camel(state.Name()))
+					}
+					for _, cond := range conds {
+						line(3, "handler.cond%s = (%s) => false;", Camel(cond), JoinParams(root.CondParams(cond), "%[1]s"))
 					}
 					line(3, "result = \"\";")
 				}
@@ -0,0 +1,163 @@
+package smc
+
+import (
+	"fmt"
+	"strings"
+)
+
+type edit struct {
+	kind byte
+	text string
+}
+
+func DiffLines(a, b []string) []edit {
+	var prefix, suffix = 0, 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var edits []edit
+	for _, text := range a[:prefix] {
+		edits = append(edits, edit{' ', text})
+	}
+	edits = diffSplit(edits, a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for _, text := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', text})
+	}
+	return edits
+}
+
+func diffSplit(edits []edit, a, b []string) []edit {
+	var insert = func(list []string) {
+		for _, text := range list {
+			edits = append(edits, edit{'+', text})
+		}
+	}
+	switch {
+	case len(a) == 0:
+		insert(b)
+		return edits
+	case len(b) == 0:
+		for _, text := range a {
+			edits = append(edits, edit{'-', text})
+		}
+		return edits
+	case len(a) == 1:
+		for idx, text := range b {
+			if text == a[0] {
+				insert(b[:idx])
+				edits = append(edits, edit{' ', text})
+				insert(b[idx+1:])
+				return edits
+			}
+		}
+		edits = append(edits, edit{'-', a[0]})
+		insert(b)
+		return edits
+	}
+	var reverse = func(list []string) []string {
+		var result = make([]string, len(list))
+		for idx, text := range list {
+			result[len(list)-1-idx] = text
+		}
+		return result
+	}
+	var mid = len(a) / 2
+	var head = lcsRow(a[:mid], b)
+	var tail = lcsRow(reverse(a[mid:]), reverse(b))
+	var split = 0
+	for j := range head {
+		if head[j]+tail[len(b)-j] > head[split]+tail[len(b)-split] {
+			split = j
+		}
+	}
+	return diffSplit(diffSplit(edits, a[:mid], b[:split]), a[mid:], b[split:])
+}
+
+func lcsRow(a, b []string) []int {
+	var row = make([]int, len(b)+1)
+	for _, text := range a {
+		var diag = 0
+		for j := range b {
+			var up = row[j+1]
+			if text == b[j] {
+				row[j+1] = diag + 1
+			} else if row[j] > up {
+				row[j+1] = row[j]
+			}
+			diag = up
+		}
+	}
+	return row
+}
+
+func UnifiedDiff(filename string, a, b []byte) string {
+	var split = func(data []byte) []string {
+		var text = strings.ReplaceAll(string(data), "\r\n", "\n")
+		if text == "" {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+	var edits = DiffLines(split(a), split(b))
+	var lines = []string{"--- " + filename, "+++ " + filename + " (generated)"}
+	var context = 3
+	for start := 0; start < len(edits); {
+		if edits[start].kind == ' ' {
+			start++
+			continue
+		}
+		var first = start - context
+		if first < 0 {
+			first = 0
+		}
+		var last, same = start, 0
+		for last < len(edits) && same <= 2*context {
+			if edits[last].kind == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+			last++
+		}
+		last -= same - context
+		if last > len(edits) {
+			last = len(edits)
+		}
+		var aline, bline = 1, 1
+		for _, edit := range edits[:first] {
+			if edit.kind != '+' {
+				aline++
+			}
+			if edit.kind != '-' {
+				bline++
+			}
+		}
+		var acount, bcount int
+		var hunk []string
+		for _, edit := range edits[first:last] {
+			if edit.kind != '+' {
+				acount++
+			}
+			if edit.kind != '-' {
+				bcount++
+			}
+			hunk = append(hunk, string(edit.kind)+edit.text)
+		}
+		if acount == 0 {
+			aline--
+		}
+		if bcount == 0 {
+			bline--
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%d,%d +%d,%d @@", aline, acount, bline, bcount))
+		lines = append(lines, hunk...)
+		start = last
+	}
+	if len(lines) == 2 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,75 @@
+package smc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	var lines = func(list ...string) string {
+		return strings.Join(list, "\n") + "\n"
+	}
+	var tests = []struct {
+		a, b  string
+		hunks string
+	}{
+		{"", "a\nb\n", lines("@@ -0,0 +1,2 @@", "+a", "+b")},
+		{"a\nb\n", "", lines("@@ -1,2 +0,0 @@", "-a", "-b")},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\nx\n",
+			lines("@@ -7,4 +7,4 @@", " 7", " 8", " 9", "-10", "+x"),
+		},
+		{
+			"1\n2\n3\n4\n5\n",
+			"1\n2\n3\n4\n5\nx\n",
+			lines("@@ -3,3 +3,4 @@", " 3", " 4", " 5", "+x"),
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"1\n2\n3\n4\n6\n7\n8\n",
+			lines("@@ -2,7 +2,6 @@", " 2", " 3", " 4", "-5", " 6", " 7", " 8"),
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"1\nx\n3\n4\n5\n6\n7\n8\ny\n10\n",
+			lines("@@ -1,10 +1,10 @@", " 1", "-2", "+x", " 3", " 4", " 5", " 6", " 7", " 8", "-9", "+y", " 10"),
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			"1\nx\n3\n4\n5\n6\n7\n8\n9\ny\n11\n",
+			lines("@@ -1,5 +1,5 @@", " 1", "-2", "+x", " 3", " 4", " 5", "@@ -7,5 +7,5 @@", " 7", " 8", " 9", "-10", "+y", " 11"),
+		},
+	}
+	if diff := UnifiedDiff("f", []byte("a\r\nb\r\n"), []byte("a\nb\n")); diff != "" {
+		t.Errorf("expecting no diff for line endings, got\n%s", diff)
+	}
+	for _, test := range tests {
+		var want = lines("--- f", "+++ f (generated)") + test.hunks
+		if got := UnifiedDiff("f", []byte(test.a), []byte(test.b)); got != want {
+			t.Errorf("%q -> %q: expecting\n%s\ngot\n%s", test.a, test.b, want, got)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	var tests = []struct {
+		a, b  string
+		edits string
+	}{
+		{"", "", ""},
+		{"a b c", "a b c", " a,  b,  c"},
+		{"a b c", "a x c", " a, -b, +x,  c"},
+		{"a b c d", "b d e", "-a,  b, -c,  d, +e"},
+		{"a a b", "b a a", "+b,  a,  a, -b"},
+	}
+	for _, test := range tests {
+		var list []string
+		for _, edit := range DiffLines(strings.Fields(test.a), strings.Fields(test.b)) {
+			list = append(list, string(edit.kind)+edit.text)
+		}
+		if got := strings.Join(list, ", "); got != test.edits {
+			t.Errorf("%q -> %q: expecting %q, got %q", test.a, test.b, test.edits, got)
+		}
+	}
+}
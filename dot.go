@@ -0,0 +1,105 @@
+package smc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+func PrintDot(file io.Writer, root *State) {
+	var line = func(idt int, format string, args ...interface{}) {
+		fmt.Fprintf(file, strings.Repeat("\t", idt))
+		fmt.Fprintf(file, format, args...)
+		fmt.Fprintf(file, "\r\n")
+	}
+	var composite = func(state *State) bool {
+		return state.Parent() != nil && state.Name() != "" && state.IsNested()
+	}
+	var label = func(event *Event) string {
+		var text = event.Name()
+		if event.IsTimer() {
+			text = "after " + event.after
+		}
+		if event.IsBranch() {
+			text = ""
+		}
+		if event.IsElse() {
+			text += " [else]"
+		} else if event.HasCond() {
+			text += fmt.Sprintf(" [%s]", event.Cond())
+		}
+		if event.Actions() != nil {
+			text += " / " + strings.Join(event.Actions(), ", ")
+		}
+		return strings.TrimSpace(text)
+	}
+	var states func(idt int, state *State)
+	states = func(idt int, state *State) {
+		for _, child := range state.Children() {
+			switch {
+			case child.Name() == "":
+				states(idt, child)
+			case composite(child):
+				line(idt, "subgraph \"cluster_%s\" {", child.Name())
+				line(idt+1, "label = \"%s\";", child.Name())
+				if child.IsRegion() {
+					line(idt+1, "style = dashed;")
+				}
+				line(idt+1, "\"%s\" [shape = point, width = 0.05];", child.Name())
+				states(idt+1, child)
+				line(idt, "}")
+			case child.IsFinal():
+				line(idt, "\"%s\" [shape = doublecircle];", child.Name())
+			case child.IsChoice():
+				line(idt, "\"%s\" [shape = diamond];", child.Name())
+			default:
+				line(idt, "\"%s\";", child.Name())
+			}
+		}
+	}
+	var edge = func(src, dst *State, attrs []string) {
+		if composite(src) && dst.IsDescendantOf(src) == false {
+			attrs = append(attrs, fmt.Sprintf("ltail = \"cluster_%s\"", src.Name()))
+		}
+		if composite(dst) && src.IsDescendantOf(dst) == false {
+			attrs = append(attrs, fmt.Sprintf("lhead = \"cluster_%s\"", dst.Name()))
+		}
+		if attrs == nil {
+			line(1, "\"%s\" -> \"%s\";", src.Name(), dst.Name())
+		} else {
+			line(1, "\"%s\" -> \"%s\" [%s];", src.Name(), dst.Name(), strings.Join(attrs, ", "))
+		}
+	}
+	var sources func(state *State) []*State
+	sources = func(state *State) (list []*State) {
+		if state.Name() != "" && state != root {
+			return []*State{state}
+		}
+		for _, child := range state.Children() {
+			list = append(list, sources(child)...)
+		}
+		return
+	}
+	line(0, "digraph \"%s\" {", root.Name())
+	line(1, "compound = true;")
+	line(1, "node [shape = box, style = rounded];")
+	line(1, "\"\" [shape = point];")
+	states(1, root)
+	if root.Start() != nil {
+		line(1, "\"\" -> \"%s\";", root.Start().Name())
+	}
+	for _, state := range root.AllDescendants(root) {
+		if state.Start() != nil && composite(state) {
+			edge(state, state.Start(), []string{"style = dashed"})
+		}
+		for _, event := range state.Events() {
+			if event.Dst() == nil {
+				continue
+			}
+			for _, src := range sources(state) {
+				edge(src, event.Dst(), []string{fmt.Sprintf("label = \"%s\"", strings.ReplaceAll(label(event), "\"", "\\\""))})
+			}
+		}
+	}
+	line(0, "}")
+}
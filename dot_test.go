@@ -0,0 +1,31 @@
+package smc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintDotRootEvents(t *testing.T) {
+	var src = `x.M {
+	start A;
+	state A { event Go { dst B; } }
+	state B { start C; state C; }
+	event Reset { dst A; }
+}`
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf = bytes.NewBuffer(nil)
+	PrintDot(buf, root)
+	for _, edge := range []string{
+		`"A" -> "A" [label = "Reset"];`,
+		`"B" -> "A" [label = "Reset", ltail = "cluster_B"];`,
+		`"A" -> "B" [label = "Go", lhead = "cluster_B"];`,
+	} {
+		if strings.Contains(buf.String(), edge) == false {
+			t.Errorf("missing edge %s in\n%s", edge, buf)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package smc
+
+import (
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+type Error struct {
+	Pos      scanner.Position
+	Token    string
+	Expected []string
+	Message  string
+}
+
+func (err *Error) Error() string {
+	var text = err.Message
+	if text == "" {
+		text = "unexpected " + err.Token
+	}
+	if len(err.Expected) != 0 {
+		text += ", expecting " + strings.Join(err.Expected, ", ")
+	}
+	if err.Pos.IsValid() || err.Pos.Filename != "" {
+		return err.Pos.String() + ": " + text
+	}
+	return text
+}
+
+type ErrorList []*Error
+
+func (list ErrorList) Error() string {
+	var lines []string
+	for _, err := range list {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (list *ErrorList) Add(err error) {
+	switch err := err.(type) {
+	case nil:
+	case *Error:
+		*list = append(*list, err)
+	case ErrorList:
+		*list = append(*list, err...)
+	default:
+		*list = append(*list, &Error{Message: err.Error()})
+	}
+}
+
+func (list ErrorList) Sort() {
+	sort.SliceStable(list, func(i, j int) bool {
+		return Before(list[i].Pos, list[j].Pos)
+	})
+}
+
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	list.Sort()
+	var unique ErrorList
+	for idx, err := range list {
+		if idx == 0 || err.Error() != list[idx-1].Error() {
+			unique = append(unique, err)
+		}
+	}
+	return unique
+}
+
+func Before(a, b scanner.Position) bool {
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
@@ -3,24 +3,41 @@ package smc
 import (
 	"fmt"
 	"strings"
+	"text/scanner"
+	"time"
 )
 
 type Event struct {
-	name string
-	cond string
-	src  *State
-	dst  *State
-	act  []string
+	name     string
+	cond     *Cond
+	src      *State
+	dst      *State
+	act      []string
+	args     [][]Param
+	pos      scanner.Position
+	ignore   []string
+	fallback bool
+	params   []Param
+	history  int
+	after    string
+	timeout  time.Duration
+	branch   bool
+	external bool
+	spliced  bool
 }
 
 func (event *Event) Name() string {
 	return event.name
 }
 
-func (event *Event) Cond() string {
+func (event *Event) Cond() *Cond {
 	return event.cond
 }
 
+func (event *Event) Params() []Param {
+	return event.params
+}
+
 func (event *Event) Src() *State {
 	return event.src
 }
@@ -33,37 +50,106 @@ func (event *Event) Actions() []string {
 	return event.act
 }
 
+func (event *Event) Args(idx int) []Param {
+	if idx < len(event.args) {
+		return event.args[idx]
+	}
+	return nil
+}
+
+func (event *Event) Pos() scanner.Position {
+	return event.pos
+}
+
 func (event *Event) IsInternal() bool {
-	return event.dst == nil || event.src.IsDescendantOf(event.dst)
+	return event.dst == nil || (event.history == 0 && event.external == false && event.src.IsDescendantOf(event.dst))
+}
+
+func (event *Event) IsExternal() bool {
+	return event.external
+}
+
+func (event *Event) IsHistory() bool {
+	return event.history != 0
+}
+
+func (event *Event) IsDeepHistory() bool {
+	return event.history == 2
+}
+
+func (event *Event) IsTimer() bool {
+	return event.after != ""
+}
+
+func (event *Event) Timeout() time.Duration {
+	return event.timeout
+}
+
+func (event *Event) IsBranch() bool {
+	return event.branch
+}
+
+func (event *Event) IsWildcard() bool {
+	return event.name == "*"
+}
+
+func (event *Event) IsDone() bool {
+	return event.name == "done"
 }
 
 func (event *Event) HasCond() bool {
-	return event.cond != ""
+	return event.cond != nil
+}
+
+func (event *Event) IsElse() bool {
+	return event.fallback
 }
 
 func (event *Event) Same(other *Event) bool {
-	return event.Name() == other.Name() && event.Cond() == other.Cond()
+	if event.HasCond() != other.HasCond() {
+		return false
+	}
+	return event.Name() == other.Name() && (event.HasCond() == false || event.Cond().String() == other.Cond().String())
 }
 
 func PrintEvent(event *Event, indent string) (lines []string) {
-	var line string
-	if event.cond == "" {
-		line = fmt.Sprintf("%sevent %s", indent, event.name)
-	} else {
-		line = fmt.Sprintf("%sevent %s if %s", indent, event.name, event.cond)
+	var line = fmt.Sprintf("%sevent %s", indent, event.name)
+	if event.after != "" {
+		line = fmt.Sprintf("%sevent after %s", indent, event.after)
+	}
+	if event.params != nil {
+		line += fmt.Sprintf("(%s)", JoinParams(event.params, "%s %s"))
+	}
+	if event.branch && event.fallback {
+		line = indent + "else"
+	} else if event.branch {
+		line = fmt.Sprintf("%sif %s", indent, event.cond)
+	} else if event.fallback {
+		line += " else"
+	} else if event.cond != nil {
+		line += fmt.Sprintf(" if %s", event.cond)
 	}
 	if event.dst != nil || event.act != nil {
 		line += " {"
-		if event.dst != nil {
-			line += fmt.Sprintf(" dst %s;", event.dst.name)
+		if event.dst != nil && event.external {
+			line += fmt.Sprintf(" dst! %s%s;", event.dst.name, []string{"", ".history", ".history*"}[event.history])
+		} else if event.dst != nil {
+			line += fmt.Sprintf(" dst %s%s;", event.dst.name, []string{"", ".history", ".history*"}[event.history])
 		}
 		if event.act != nil {
-			line += fmt.Sprintf(" act %s;", strings.Join(event.act, ", "))
+			var acts []string
+			for idx, act := range event.act {
+				if args := event.Args(idx); args != nil {
+					act += "(" + JoinParams(args, "%[1]s") + ")"
+				}
+				acts = append(acts, act)
+			}
+			line += fmt.Sprintf(" act %s;", strings.Join(acts, ", "))
 		}
 		line += " }"
 	} else {
 		line += ";"
 	}
-	lines = append(lines, line)
+	lines = append(PrintIgnore(event.ignore, indent), line)
 	return
 }
@@ -2,34 +2,276 @@ package smc
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"text/scanner"
 )
 
-func CheckWriteFile(filename string, text []byte) {
+func GeneratedRegion(text string) (int, int, bool) {
+	var begin, end, offset = -1, -1, 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		switch strings.TrimSpace(line) {
+		case "// smc:begin":
+			if begin < 0 {
+				begin = offset + len(line)
+			}
+		case "// smc:end":
+			if begin >= 0 && end < 0 {
+				end = offset
+			}
+		}
+		offset += len(line)
+	}
+	return begin, end, begin >= 0 && end >= 0
+}
+
+func SpliceRegion(head, text, tail string) []byte {
+	var cut = func() string {
+		var line = text
+		if idx := strings.Index(text, "\r\n"); idx >= 0 {
+			line, text = text[:idx], text[idx+2:]
+		}
+		text = strings.TrimPrefix(text, "\r\n")
+		return line
+	}
+	var directive = func(line string) bool {
+		for _, prefix := range []string{"package ", "import ", "using ", "#pragma once", "#include "} {
+			if strings.HasPrefix(line, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	var spec = func(line string) string {
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+		return strings.Join(strings.Fields(line), " ")
+	}
+	var present = make(map[string]bool)
+	var block = false
+	for _, line := range strings.Split(head, "\n") {
+		var trimmed = spec(line)
+		switch {
+		case block && trimmed == ")":
+			block = false
+		case block:
+			present["import "+trimmed] = true
+		case trimmed == "import (":
+			block = true
+		default:
+			present[trimmed] = true
+		}
+	}
+	var eol = "\n"
+	if idx := strings.Index(head, "\n"); idx > 0 && head[idx-1] == '\r' {
+		eol = "\r\n"
+	}
+	var hoisted string
+	for directive(text) {
+		if line := cut(); strings.HasPrefix(line, "package ") == false && present[spec(line)] == false {
+			hoisted += line + eol
+		}
+	}
+	if eol == "\n" {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	if hoisted == "" {
+		return []byte(head + text + tail)
+	}
+	var offset, at = 0, -1
+	for _, line := range strings.SplitAfter(head, "\n") {
+		var trimmed = strings.TrimSpace(line)
+		offset += len(line)
+		if strings.HasPrefix(trimmed, "package ") {
+			at, hoisted = offset, eol+hoisted
+			break
+		}
+		if directive(trimmed) {
+			at = offset
+		} else if trimmed != "" && strings.HasPrefix(trimmed, "//") == false {
+			break
+		}
+	}
+	if at < 0 {
+		at, hoisted = 0, hoisted+eol
+	}
+	return []byte(head[:at] + hoisted + head[at:] + text + tail)
+}
+
+func SplicedText(filename string, text []byte) []byte {
 	if data, err := os.ReadFile(filename); err == nil {
-		if bytes.Equal(text, data) {
-			return
+		if begin, end, ok := GeneratedRegion(string(data)); ok {
+			return SpliceRegion(string(data[:begin]), string(text), string(data[end:]))
 		}
 	}
-	if err := os.WriteFile(filename, text, 0666); err != nil {
-		panic("unable to create file " + filename)
+	return text
+}
+
+func CheckWriteFile(filename string, text []byte) error {
+	return WriteFile(filename, SplicedText(filename, text))
+}
+
+func CompareFile(filename string, text []byte) error {
+	var data, _ = os.ReadFile(filename)
+	if EqualLines(text, data) {
+		return nil
 	}
+	fmt.Print(UnifiedDiff(filename, data, text))
+	return &Error{Pos: scanner.Position{Filename: filename}, Message: "not up to date"}
+}
+
+func EqualLines(a, b []byte) bool {
+	return bytes.Equal(bytes.ReplaceAll(a, []byte("\r\n"), []byte("\n")), bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")))
 }
 
-func ReadRoot(filename string) string {
+func WriteFile(filename string, text []byte) error {
 	if data, err := os.ReadFile(filename); err == nil {
-		var text = string(data)
-		if first := strings.Index(text, "/**") + 3; first != 2 {
-			if last := strings.Index(text, "**/"); last != -1 {
-				return text[first:last]
-			} else {
-				panic(filename + ": expecting /** ... **/")
+		if EqualLines(text, data) {
+			return nil
+		}
+	}
+	if err := os.WriteFile(filename, text, 0666); err != nil {
+		return &Error{Pos: scanner.Position{Filename: filename}, Message: "unable to create file"}
+	}
+	return nil
+}
+
+func WriteOutput(filename string, text []byte) error {
+	if filename == "-" {
+		var _, err = os.Stdout.Write(text)
+		return err
+	}
+	return CheckWriteFile(filename, text)
+}
+
+func OutputName(filename, lang string) string {
+	if filepath.Ext(filename) != ".sm" {
+		return filename
+	}
+	var dir, base = filepath.Split(strings.TrimSuffix(filename, ".sm"))
+	switch lang {
+	case "go":
+		return filepath.Join(dir, base+"_sm.go")
+	case "cs", "lms-cs":
+		return filepath.Join(dir, Camel(base)+".g.cs")
+	case "cpp":
+		return filepath.Join(dir, base+".sm.hpp")
+	}
+	return filename
+}
+
+func RootBlocks(filename, text string) (blocks [][2]int) {
+	if filepath.Ext(filename) == ".sm" {
+		return [][2]int{{0, len(text)}}
+	}
+	var offset, limit = 0, len(text)
+	if begin, end, ok := GeneratedRegion(text); ok {
+		offset, limit = begin, end
+	}
+	for {
+		var first = strings.Index(text[offset:limit], "/**")
+		if first < 0 {
+			break
+		}
+		first += offset + 3
+		var last = strings.Index(text[first:limit], "**/")
+		if last < 0 {
+			break
+		}
+		last += first
+		blocks = append(blocks, [2]int{first, last})
+		offset = last + 3
+	}
+	return
+}
+
+func ReadRoots(filename string) ([]string, error) {
+	var data, err = os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var text, roots = string(data), []string(nil)
+	for _, block := range RootBlocks(filename, text) {
+		roots = append(roots, Blank(text[:block[0]])+text[block[0]:block[1]])
+	}
+	if roots == nil {
+		return nil, &Error{Pos: scanner.Position{Filename: filename}, Message: "expecting /** ... **/"}
+	}
+	return roots, nil
+}
+
+func FormatRoots(filename string, roots []*State) ([]byte, error) {
+	var data, err = os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var text, eol = string(data), "\n"
+	if strings.Contains(text, "\r\n") {
+		eol = "\r\n"
+	}
+	var blocks = RootBlocks(filename, text)
+	for _, block := range blocks {
+		var scan = new(scanner.Scanner)
+		scan.Init(strings.NewReader(Blank(text[:block[0]]) + text[block[0]:block[1]]))
+		scan.Filename = filename
+		scan.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanStrings | scanner.ScanComments
+		scan.Error = func(*scanner.Scanner, string) {}
+		for next := scan.Scan(); next != scanner.EOF; next = scan.Scan() {
+			if _, ok := Directive(scan.TokenText()); next == scanner.Comment && ok == false {
+				return nil, &Error{Pos: scan.Position, Message: "comments would be lost, only smc:ignore comments can be formatted"}
+			}
+		}
+	}
+	for idx := len(blocks) - 1; idx >= 0; idx-- {
+		var src = strings.Join(PrintRoot(roots[idx], ""), eol) + eol
+		if filepath.Ext(filename) != ".sm" {
+			src = eol + src
+		}
+		text = text[:blocks[idx][0]] + src + text[blocks[idx][1]:]
+	}
+	return []byte(text), nil
+}
+
+func JoinOutputs(outputs [][]byte) []byte {
+	if len(outputs) == 1 {
+		return outputs[0]
+	}
+	var groups [][]string
+	var bodies []string
+	for _, output := range outputs {
+		var text = string(output)
+		var first = strings.Index(text, "/**\r\n")
+		if first < 0 {
+			first = 0
+		}
+		var group = 0
+		for _, line := range strings.Split(text[:first], "\r\n") {
+			if line == "" {
+				group++
+				continue
+			}
+			for len(groups) <= group {
+				groups = append(groups, nil)
 			}
-		} else {
-			panic(filename + ": expecting /** ... **/")
+			var found = false
+			for _, str := range groups[group] {
+				found = found || str == line
+			}
+			if found == false {
+				groups[group] = append(groups[group], line)
+			}
+		}
+		bodies = append(bodies, text[first:])
+	}
+	var buf = bytes.NewBuffer(nil)
+	for _, group := range groups {
+		if group != nil {
+			buf.WriteString(strings.Join(group, "\r\n") + "\r\n\r\n")
 		}
-	} else {
-		panic(err)
 	}
+	buf.WriteString(strings.Join(bodies, "\r\n"))
+	return buf.Bytes()
 }
@@ -0,0 +1,139 @@
+package smc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedRegion(t *testing.T) {
+	var tests = []struct {
+		text       string
+		begin, end int
+		ok         bool
+	}{
+		{"a\n// smc:begin\nb\n// smc:end\nc\n", 15, 17, true},
+		{"a\r\n\t// smc:begin\r\n// smc:end\r\n", 18, 18, true},
+		{"// smc:end\n// smc:begin\nb\n", 24, -1, false},
+		{"a\nb\n", -1, -1, false},
+	}
+	for _, test := range tests {
+		var begin, end, ok = GeneratedRegion(test.text)
+		if begin != test.begin || end != test.end || ok != test.ok {
+			t.Errorf("%q: expecting %d %d %v, got %d %d %v", test.text, test.begin, test.end, test.ok, begin, end, ok)
+		}
+	}
+}
+
+func TestSpliceRegion(t *testing.T) {
+	var tests = []struct {
+		head, text, want string
+	}{
+		{
+			"package p\n\nfunc F() {}\n// smc:begin\n",
+			"package p\r\n\r\nimport \"time\"\r\n\r\nvar x time.Duration\r\n",
+			"package p\n\nimport \"time\"\n\nfunc F() {}\n// smc:begin\nvar x time.Duration\n",
+		},
+		{
+			"package p\n\nimport \"time\"\n// smc:begin\n",
+			"package p\r\n\r\nimport \"time\"\r\n\r\nvar x time.Duration\r\n",
+			"package p\n\nimport \"time\"\n// smc:begin\nvar x time.Duration\n",
+		},
+		{
+			"using System.Text;\n\nnamespace A {\n}\n// smc:begin\n",
+			"using System;\r\n\r\nnamespace B {\r\n}\r\n",
+			"using System.Text;\nusing System;\n\nnamespace A {\n}\n// smc:begin\nnamespace B {\n}\n",
+		},
+		{
+			"using System;\nnamespace A {\n}\n// smc:begin\n",
+			"using System;\r\n\r\nnamespace B {\r\n}\r\n",
+			"using System;\nnamespace A {\n}\n// smc:begin\nnamespace B {\n}\n",
+		},
+		{
+			"// header\nint x;\n// smc:begin\n",
+			"#pragma once\r\n\r\n#include <chrono>\r\n\r\nstruct M {};\r\n",
+			"#pragma once\n#include <chrono>\n\n// header\nint x;\n// smc:begin\nstruct M {};\n",
+		},
+		{
+			"package p\r\n\r\n// no \"time\" here\r\n// smc:begin\r\n",
+			"package p\r\n\r\nimport \"time\"\r\n\r\nvar x time.Duration\r\n",
+			"package p\r\n\r\nimport \"time\"\r\n\r\n// no \"time\" here\r\n// smc:begin\r\nvar x time.Duration\r\n",
+		},
+		{
+			"package p\n\nimport (\n\t\"fmt\"\n\t\"time\" // clock\n)\n// smc:begin\n",
+			"package p\r\n\r\nimport \"time\"\r\n\r\nvar x time.Duration\r\n",
+			"package p\n\nimport (\n\t\"fmt\"\n\t\"time\" // clock\n)\n// smc:begin\nvar x time.Duration\n",
+		},
+		{
+			"package p\n\nimport t \"time\"\n// smc:begin\n",
+			"package p\r\n\r\nimport \"time\"\r\n\r\nvar x time.Duration\r\n",
+			"package p\n\nimport \"time\"\n\nimport t \"time\"\n// smc:begin\nvar x time.Duration\n",
+		},
+	}
+	for _, test := range tests {
+		if got := string(SpliceRegion(test.head, test.text, "// smc:end\n")); got != test.want+"// smc:end\n" {
+			t.Errorf("%q: expecting\n%q, got\n%q", test.head, test.want+"// smc:end\n", got)
+		}
+	}
+}
+
+func TestFormatRoots(t *testing.T) {
+	var tests = []struct {
+		src, want, message string
+	}{
+		{"x.M {\n  start A;\n\t// smc:ignore trap\n\tstate A;\n}\n", "x.M {\n\tstart A;\n\t// smc:ignore trap\n\tstate A;\n}\n", ""},
+		{"x.M {\r\n\tstart A;\r\n\tstate A;\r\n}\r\n", "x.M {\r\n\tstart A;\r\n\tstate A;\r\n}\r\n", ""},
+		{"x.M {\n\tstart A; // initial\n\tstate A;\n}\n", "", "test.sm:2:11: comments would be lost"},
+		{"x.M {\n\t/* states */\n\tstart A;\n\tstate A;\n}\n", "", "test.sm:2:2: comments would be lost"},
+	}
+	var filename = filepath.Join(t.TempDir(), "test.sm")
+	for _, test := range tests {
+		if err := os.WriteFile(filename, []byte(test.src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		var roots, err = LoadRoots(filename, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := FormatRoots(filename, roots)
+		switch {
+		case test.message != "" && (err == nil || strings.Contains(err.Error(), test.message) == false):
+			t.Errorf("%q: expecting %q, got %v", test.src, test.message, err)
+		case test.message == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", test.src, err)
+		case test.message == "" && string(text) != test.want:
+			t.Errorf("%q: expecting %q, got %q", test.src, test.want, text)
+		}
+	}
+}
+
+func TestCompareFileLineEndings(t *testing.T) {
+	var filename = filepath.Join(t.TempDir(), "test_sm.go")
+	if err := os.WriteFile(filename, []byte("package x\n\nvar a = 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompareFile(filename, []byte("package x\r\n\r\nvar a = 1\r\n")); err != nil {
+		t.Errorf("expecting line endings to be ignored, got %v", err)
+	}
+	if err := CompareFile(filename, []byte("package x\r\n\r\nvar a = 2\r\n")); err == nil {
+		t.Errorf("expecting a difference")
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	var tests = []struct {
+		filename, lang, want string
+	}{
+		{filepath.Join("dir", "door.sm"), "go", filepath.Join("dir", "door_sm.go")},
+		{filepath.Join("dir", "door_lock.sm"), "cs", filepath.Join("dir", "DoorLock.g.cs")},
+		{"door.sm", "lms-cs", "Door.g.cs"},
+		{"door.sm", "cpp", "door.sm.hpp"},
+		{"door.go", "go", "door.go"},
+	}
+	for _, test := range tests {
+		if got := OutputName(test.filename, test.lang); got != test.want {
+			t.Errorf("%s %s: expecting %s, got %s", test.filename, test.lang, test.want, got)
+		}
+	}
+}
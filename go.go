@@ -8,28 +8,123 @@ import (
 
 func PrintGo(file io.Writer, root *State, source []string) {
 	var events = make(map[string]map[string][]*Event)
+	var allhist = root.AllHistories()
+	var alldefer = root.AllDeferred()
 	var line = func(idt int, format string, args ...interface{}) {
 		fmt.Fprintf(file, strings.Repeat("\t", idt))
 		fmt.Fprintf(file, format, args...)
 		fmt.Fprintf(file, "\r\n")
 	}
+	var args = func(params []Param, event *Event) string {
+		if len(params) == 0 {
+			return ""
+		}
+		return JoinParams(root.EventParams(event.Name()), "%[1]s")
+	}
+	var record = func(idt int, dst *State) {
+		for _, history := range allhist {
+			if dst.IsDescendantOf(history) {
+				line(idt, "this.history%s = \"%s\"", Camel(history.Name()), Camel(dst.Name()))
+			}
+		}
+	}
+	var current = func(slot *State) string {
+		if slot.IsRegion() {
+			return "current" + Camel(slot.Name())
+		}
+		return "currentState"
+	}
+	var steps = func(idt int, event *Event, steps []Step) {
+		for _, step := range steps {
+			if step.Exit != nil {
+				line(idt, "this.exit%s()", Camel(step.Exit.Name()))
+			} else if step.Start != nil {
+				line(idt, "this.Timer.StartTimer(\"%s\", %d*time.Millisecond, this.fire%s)", Camel(step.Start.Name()), step.Start.Timeout().Milliseconds(), Camel(step.Start.Name()))
+			} else if step.Stop != nil {
+				line(idt, "this.Timer.StopTimer(\"%s\")", Camel(step.Stop.Name()))
+			} else if step.Args != nil {
+				line(idt, "this.On%s(%s)", Camel(step.Act), JoinArgs(step.Args))
+			} else {
+				line(idt, "this.On%s(%s)", Camel(step.Act), args(root.ActionParams(step.Act), event))
+			}
+		}
+	}
+	var label = func(state *State) string {
+		if state.Parent() == nil {
+			return ""
+		}
+		return Camel(state.Name())
+	}
+	var complete = func(idt int, dsts []*State) {
+		for _, dst := range dsts {
+			if state := MakeCompletion(dst); state != nil && state.EventsGrouped()["done"] != nil {
+				line(idt, "this.complete%s()", label(state))
+			}
+		}
+	}
+	var branch = func(idt int, event *Event, list []Step, dsts []*State) {
+		if len(dsts) != 0 && len(list) != 0 {
+			line(idt, "this.%s = \"none\"", current(event.Src().Region()))
+		}
+		steps(idt, event, list)
+		for _, dst := range dsts {
+			line(idt, "this.%s = \"%s\"", current(dst.Region()), Camel(dst.Name()))
+			record(idt, dst)
+		}
+		complete(idt, dsts)
+		if alldefer != nil && len(dsts) != 0 {
+			line(idt, "this.replay()")
+		}
+	}
+	var guard = func(cond *Cond, event *Event) string {
+		return cond.Format(func(name string) string {
+			return "this.Cond" + Camel(name) + "(" + args(root.CondParams(name), event) + ")"
+		})
+	}
+	var cond = func(event *Event) string {
+		return guard(event.Cond(), event)
+	}
 	var transition = func(idt int, event *Event) {
-		var actions, dst = MakeTransition(event)
-		if dst != nil && len(actions) != 0 {
-			line(idt, "this.currentState = \"none\"")
+		var branches = MakeBranches(event)
+		if len(branches) == 1 {
+			branch(idt, event, branches[0].Steps, branches[0].Dsts)
+			return
 		}
-		for _, act := range actions {
-			line(idt, "this.On%s()", Camel(act))
+		if event.Dst().IsChoice() {
+			for idx, br := range branches {
+				if idx == 0 {
+					line(idt, "if %s {", guard(br.Cond, event))
+				} else if br.Cond != nil {
+					line(idt, "} else if %s {", guard(br.Cond, event))
+				} else {
+					line(idt, "} else {")
+				}
+				branch(idt+1, event, br.Steps, br.Dsts)
+			}
+			line(idt, "}")
+			return
 		}
-		if dst != nil {
-			line(idt, "this.currentState = \"%s\"", Camel(dst.Name()))
+		line(idt, "switch this.history%s {", Camel(event.Dst().Name()))
+		for _, br := range branches {
+			if br.Keys == nil {
+				line(idt, "default:")
+			} else {
+				var keys []string
+				for _, key := range br.Keys {
+					keys = append(keys, "\""+Camel(key.Name())+"\"")
+				}
+				line(idt, "case %s:", strings.Join(keys, ", "))
+			}
+			branch(idt+1, event, br.Steps, br.Dsts)
 		}
+		line(idt, "}")
 	}
 	var empty = func(events []*Event) bool {
 		for _, event := range events {
-			var actions, dst = MakeTransition(event)
-			if dst != nil || len(actions) != 0 {
-				return false
+			for _, branch := range MakeBranches(event) {
+				if len(branch.Dsts) != 0 || len(branch.Steps) != 0 {
+					return false
+				}
 			}
 		}
 		return true
@@ -38,64 +133,236 @@ func PrintGo(file io.Writer, root *State, source []string) {
 	var allcond = root.AllConditions()
 	var allact = root.AllActions()
 	var allev = root.AllEvents()
+	var allreg = root.AllRegions()
+	var alltimer = root.AllTimers()
 	for _, state := range root.AllDescendants(root) {
-		if state.IsLeaf() {
-			events[state.Name()] = state.EventsGrouped()
+		if state.IsAtomic() {
+			events[state.Name()] = root.Handlers(state)
 		}
 	}
 	line(0, "package %s", strings.Join(ns, ""))
 	line(0, "")
+	if alltimer != nil {
+		line(0, "import \"time\"")
+		line(0, "")
+	}
 	line(0, "/**")
 	line(0, strings.Join(source, "\r\n"))
 	line(0, "**/")
 	line(0, "")
 	line(0, "type %s struct {", name)
 	for _, act := range allact {
-		line(1, "On%s func()", Camel(act))
+		line(1, "On%s func(%s)", Camel(act), JoinParams(root.ActionParams(act), "%s %s"))
 	}
 	for _, cond := range allcond {
-		line(1, "Cond%s func() bool", Camel(cond))
+		line(1, "Cond%s func(%s) bool", Camel(cond), JoinParams(root.CondParams(cond), "%s %s"))
+	}
+	line(1, "OnUnhandled func(name string)")
+	if alltimer != nil {
+		line(1, "Timer interface {")
+		line(2, "StartTimer(name string, duration time.Duration, fire func())")
+		line(2, "StopTimer(name string)")
+		line(1, "}")
 	}
 	line(1, "currentState string")
+	for _, region := range allreg {
+		line(1, "%s string", current(region))
+	}
+	for _, history := range allhist {
+		line(1, "history%s string", Camel(history.Name()))
+	}
+	if alldefer != nil {
+		line(1, "deferred []func()")
+	}
 	line(0, "}")
 	line(0, "")
-	for _, evname := range allev {
-		line(0, "func (this *%s) Send%s() {", name, Camel(evname))
-		line(1, "switch this.currentState {")
-		for _, state := range root.AllDescendants(root) {
-			if state.IsNested() {
-				continue
-			}
-			if evs, found := events[state.Name()][evname]; found {
-				if empty(evs) {
-					continue
-				}
-				line(1, "case \"%s\":", Camel(state.Name()))
+	var dispatch = func(slot *State, evname string) {
+		var ret, timer = ";", false
+		if slot.IsRegion() {
+			ret = " true"
+		}
+		for _, other := range alltimer {
+			timer = timer || other.Name() == evname
+		}
+		var group = func(evs []*Event) {
+			if empty(evs) == false {
 				for _, event := range evs {
 					if event.HasCond() {
-						line(2, "if this.Cond%s() {", Camel(event.Cond()))
+						line(2, "if %s {", cond(event))
 						transition(3, event)
-						line(3, "return;")
+						line(3, "return%s", ret)
 						line(2, "}")
 					} else {
 						transition(2, event)
 					}
 				}
 			}
+			if slot.IsRegion() {
+				line(2, "return true")
+			}
+		}
+		line(1, "switch this.%s {", current(slot))
+		for _, state := range root.AllDescendants(root) {
+			if state.IsAtomic() == false || state.Region() != slot {
+				continue
+			}
+			if state.IsParallel() && state.Declares(evname) {
+				line(1, "case \"%s\":", Camel(state.Name()))
+				line(2, "var handled = false")
+				var first = true
+				for _, region := range state.Children() {
+					if region.Declares(evname) {
+						if first == false {
+							line(2, "if this.%s != \"%s\" {", current(slot), Camel(state.Name()))
+							line(3, "return%s", ret)
+							line(2, "}")
+						}
+						line(2, "handled = this.send%sIn%s(%s) || handled", Camel(evname), Camel(region.Name()), JoinParams(root.EventParams(evname), "%[1]s"))
+						first = false
+					}
+				}
+				if evs, found := events[state.Name()][evname]; found {
+					line(2, "if handled {")
+					line(3, "return%s", ret)
+					line(2, "}")
+					group(evs)
+				} else if slot.IsRegion() {
+					line(2, "return handled")
+				} else if timer == false {
+					line(2, "if handled == false && this.OnUnhandled != nil {")
+					line(3, "this.OnUnhandled(\"%s\")", Camel(evname))
+					line(2, "}")
+				}
+				continue
+			}
+			if state.Defers(evname) {
+				line(1, "case \"%s\":", Camel(state.Name()))
+				line(2, "this.deferred = append(this.deferred, func() {")
+				line(3, "this.Send%s(%s)", Camel(evname), JoinParams(root.EventParams(evname), "%[1]s"))
+				line(2, "})")
+				if slot.IsRegion() {
+					line(2, "return true")
+				}
+				continue
+			}
+			if evs, found := events[state.Name()][evname]; found {
+				line(1, "case \"%s\":", Camel(state.Name()))
+				group(evs)
+			}
 		}
 		line(1, "case \"none\":")
 		line(2, "panic(\"invalid state\")")
+		if slot.IsRegion() {
+			line(1, "}")
+			line(1, "return false")
+			return
+		}
+		if timer == false {
+			line(1, "default:")
+			line(2, "if this.OnUnhandled != nil {")
+			line(3, "this.OnUnhandled(\"%s\")", Camel(evname))
+			line(2, "}")
+		}
 		line(1, "}")
+	}
+	for _, evname := range allev {
+		line(0, "func (this *%s) Send%s(%s) {", name, Camel(evname), JoinParams(root.EventParams(evname), "%s %s"))
+		dispatch(root, evname)
 		line(0, "}")
 	}
+	for _, timer := range alltimer {
+		line(0, "func (this *%s) fire%s() {", name, Camel(timer.Name()))
+		dispatch(root, timer.Name())
+		line(0, "}")
+	}
+	for _, region := range allreg {
+		for _, evname := range root.AllSignals() {
+			if region.Declares(evname) {
+				line(0, "func (this *%s) send%sIn%s(%s) bool {", name, Camel(evname), Camel(region.Name()), JoinParams(root.EventParams(evname), "%s %s"))
+				dispatch(region, evname)
+				line(0, "}")
+			}
+		}
+	}
 	line(0, "")
-	var actions, dst = MakeStart(root)
+	var actions, dsts = MakeStart(root)
 	line(0, "func (this *%s) Start() {", name)
 	line(1, "if this.currentState == \"\" {")
-	for _, act := range actions {
-		line(2, "this.On%s();", Camel(act))
+	for _, step := range actions {
+		if step.Act != "" {
+			line(2, "this.On%s();", Camel(step.Act))
+		} else {
+			steps(2, nil, []Step{step})
+		}
 	}
-	line(2, "this.currentState = \"%s\"", Camel(dst.Name()))
+	for _, dst := range dsts {
+		line(2, "this.%s = \"%s\"", current(dst.Region()), Camel(dst.Name()))
+		record(2, dst)
+	}
+	complete(2, dsts)
 	line(1, "}")
 	line(0, "}")
+	if finals := root.Finals(); finals != nil {
+		var list []string
+		for _, final := range finals {
+			list = append(list, fmt.Sprintf("this.currentState == \"%s\"", Camel(final.Name())))
+		}
+		line(0, "")
+		line(0, "func (this *%s) IsTerminated() bool {", name)
+		line(1, "return %s", strings.Join(list, " || "))
+		line(0, "}")
+	}
+	for _, state := range root.AllDescendants(root) {
+		var events = state.EventsGrouped()["done"]
+		if events == nil {
+			continue
+		}
+		line(0, "")
+		line(0, "func (this *%s) complete%s() {", name, label(state))
+		if state.IsParallel() {
+			for _, region := range state.Children() {
+				var list []string
+				for _, final := range region.Finals() {
+					list = append(list, fmt.Sprintf("this.%s != \"%s\"", current(region), Camel(final.Name())))
+				}
+				line(1, "if %s {", strings.Join(list, " && "))
+				line(2, "return")
+				line(1, "}")
+			}
+		}
+		for _, event := range events {
+			if event.HasCond() {
+				line(1, "if %s {", cond(event))
+				transition(2, event)
+				line(2, "return;")
+				line(1, "}")
+			} else {
+				transition(1, event)
+			}
+		}
+		line(0, "}")
+	}
+	if alldefer != nil {
+		line(0, "")
+		line(0, "func (this *%s) replay() {", name)
+		line(1, "var deferred = this.deferred")
+		line(1, "this.deferred = nil")
+		line(1, "for _, send := range deferred {")
+		line(2, "send()")
+		line(1, "}")
+		line(0, "}")
+	}
+	for _, region := range allreg {
+		line(0, "")
+		line(0, "func (this *%s) exit%s() {", name, Camel(region.Name()))
+		line(1, "switch this.%s {", current(region))
+		for _, state := range region.AllDescendants() {
+			if state.IsAtomic() && state.Region() == region {
+				line(1, "case \"%s\":", Camel(state.Name()))
+				steps(2, nil, MakeRegionExit(region, state))
+			}
+		}
+		line(1, "}")
+		line(0, "}")
+	}
 }
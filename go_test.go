@@ -0,0 +1,197 @@
+package smc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func generate(t *testing.T, print func(io.Writer, *State, []string), src string) string {
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines = PrintRoot(root, "")
+	var buf = bytes.NewBuffer(nil)
+	root.PushEvents()
+	print(buf, root, lines)
+	return strings.ReplaceAll(buf.String(), "\r\n", "\n")
+}
+
+func expectLines(t *testing.T, text string, present []string, absent []string) {
+	for _, line := range present {
+		if strings.Contains(text, line) == false {
+			t.Errorf("missing %q", line)
+		}
+	}
+	for _, line := range absent {
+		if strings.Contains(text, line) {
+			t.Errorf("unexpected %q", line)
+		}
+	}
+	if t.Failed() {
+		t.Log(text)
+	}
+}
+
+func TestPrintGoTimers(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	start A;
+	state A { event after 1s { dst B; } }
+	state B { event AAfter1s { dst A; } }
+}`)
+	expectLines(t, text, []string{
+		"\t\tthis.Timer.StartTimer(\"ATimer\", 1000*time.Millisecond, this.fireATimer)\n",
+		"\t\tthis.Timer.StopTimer(\"ATimer\")\n",
+		"func (this *M) fireATimer() {\n",
+		"func (this *M) SendAAfter1s() {\n",
+	}, []string{
+		"SendATimer",
+	})
+}
+
+func TestPrintGoParams(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	start A;
+	state A {
+		event Data(bytes []byte) if Valid { dst B; act Store; }
+		event Data else { act Drop; }
+	}
+	state B;
+}`)
+	expectLines(t, text, []string{
+		"\tOnStore func(bytes []byte)\n",
+		"\tCondValid func(bytes []byte) bool\n",
+		"func (this *M) SendData(bytes []byte) {\n",
+		"\t\tif this.CondValid(bytes) {\n",
+		"\t\t\tthis.OnStore(bytes)\n",
+		"\t\tthis.OnDrop(bytes)\n",
+	}, nil)
+}
+
+func TestPrintGoCompletion(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	start Job;
+	state Job {
+		start Run;
+		event done { dst Over; act Report; }
+		state Run { event Finish { dst Finished; } }
+		final Finished;
+	}
+	final Over;
+}`)
+	expectLines(t, text, []string{
+		"\t\tthis.currentState = \"Finished\"\n\t\tthis.completeJob()\n",
+		"func (this *M) completeJob() {\n",
+		"\tthis.OnReport()\n\tthis.currentState = \"Over\"\n",
+		"\treturn this.currentState == \"Over\"\n",
+	}, []string{
+		"SendDone",
+	})
+}
+
+func TestPrintGoChoice(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	start A;
+	state A {
+		exit LeaveA;
+		event Go { dst K; act Count; }
+	}
+	choice K {
+		if Full { dst B; act Warn; }
+		else { dst C; }
+	}
+	state B { entry EnterB; }
+	state C;
+}`)
+	expectLines(t, text, []string{
+		"\t\tif this.CondFull() {\n\t\t\tthis.currentState = \"none\"\n\t\t\tthis.OnLeaveA()\n\t\t\tthis.OnCount()\n\t\t\tthis.OnWarn()\n\t\t\tthis.OnEnterB()\n\t\t\tthis.currentState = \"B\"\n",
+		"\t\t} else {\n\t\t\tthis.currentState = \"none\"\n\t\t\tthis.OnLeaveA()\n\t\t\tthis.OnCount()\n\t\t\tthis.currentState = \"C\"\n",
+	}, []string{
+		"\"K\"",
+	})
+}
+
+func TestPrintGoDefer(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	start Idle;
+	state Idle {
+		event Job(id int) { dst Busy; act Begin; }
+	}
+	state Busy {
+		defer Job;
+		event Done { dst Idle; }
+	}
+}`)
+	expectLines(t, text, []string{
+		"\tcase \"Busy\":\n\t\tthis.deferred = append(this.deferred, func() {\n\t\t\tthis.SendJob(id)\n\t\t})\n",
+		"\t\tthis.currentState = \"Idle\"\n\t\tthis.replay()\n",
+		"\t\tthis.currentState = \"Busy\"\n\t\tthis.replay()\n",
+		"func (this *M) replay() {\n",
+	}, nil)
+}
+
+func TestPrintGoExternal(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	start P;
+	state P {
+		start A;
+		entry EnterP;
+		exit LeaveP;
+		state A {
+			entry EnterA;
+			exit LeaveA;
+			event Reset { dst! P; }
+			event Poke { dst P; }
+			event Go { dst B; }
+		}
+		state B;
+	}
+}`)
+	expectLines(t, text, []string{
+		"\tcase \"A\":\n\t\tthis.currentState = \"none\"\n\t\tthis.OnLeaveA()\n\t\tthis.OnLeaveP()\n\t\tthis.OnEnterP()\n\t\tthis.OnEnterA()\n\t\tthis.currentState = \"A\"\n",
+		"func (this *M) SendPoke() {\n\tswitch this.currentState {\n\tcase \"A\":\n\tcase \"none\":\n",
+	}, nil)
+}
+
+func TestPrintGoDeclarations(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	events { Go, Stop }
+	actions { Connect, Spare }
+	conditions { Ready, Later }
+	start A;
+	state A {
+		event Go if Ready { dst B; act Connect; }
+		event Go else;
+	}
+	state B;
+}`)
+	expectLines(t, text, []string{
+		"\tOnSpare func()\n",
+		"\tCondLater func() bool\n",
+		"func (this *M) SendStop() {\n",
+	}, nil)
+}
+
+func TestPrintGoActionArgs(t *testing.T) {
+	var text = generate(t, PrintGo, `x.M {
+	start Idle;
+	state Idle {
+		event Go { dst Run; act SetLed(Red), Log("go \"now\""); }
+		event Fail(reason string, code int) { act Report(code, 3, reason); }
+	}
+	state Run {
+		event Stop { dst Idle; act SetLed(Green); }
+	}
+}`)
+	expectLines(t, text, []string{
+		"\tOnSetLed func(arg1 string)\n",
+		"\tOnReport func(code int, arg2 int, reason string)\n",
+		"\t\tthis.OnSetLed(\"Red\")\n\t\tthis.OnLog(\"go \\\"now\\\"\")\n",
+		"\t\tthis.OnReport(code, 3, reason)\n",
+		"\t\tthis.OnSetLed(\"Green\")\n",
+	}, []string{
+		"OnSetLedRed",
+	})
+}
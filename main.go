@@ -2,41 +2,296 @@ package smc
 
 import (
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"runtime/debug"
 	"strings"
 )
 
+type Backend struct {
+	Name  string
+	Help  string
+	Print func(file io.Writer, root *State, source []string)
+}
+
+var Backends = []Backend{
+	{Name: "cs", Help: "C# class with a handler interface", Print: PrintCs},
+	{Name: "cpp", Help: "C++ header with a handler struct", Print: PrintCpp},
+	{Name: "go", Help: "Go struct with callback fields", Print: PrintGo},
+	{Name: "lms-cs", Help: "C# class with state logging", Print: PrintLmsCs},
+}
+
+var Commands = []string{"gen", "check", "fmt", "graph", "version"}
+
+type UsageError struct {
+	Message string
+}
+
+func (err *UsageError) Error() string {
+	return err.Message
+}
+
+func FindBackend(name string) *Backend {
+	for idx := range Backends {
+		if Backends[idx].Name == name {
+			return &Backends[idx]
+		}
+	}
+	return nil
+}
+
+func BackendNames() []string {
+	var names []string
+	for _, backend := range Backends {
+		names = append(names, backend.Name)
+	}
+	return names
+}
+
+func Version() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+func Usage(file io.Writer) {
+	fmt.Fprintln(file, "usage: smc <command> [flags] <file>")
+	fmt.Fprintln(file, "")
+	fmt.Fprintln(file, "commands:")
+	fmt.Fprintln(file, "  gen -lang <lang> [-o <output>] [-check] <file>   generate code, - writes to stdout")
+	fmt.Fprintln(file, "  check <file>                                    report warnings")
+	fmt.Fprintln(file, "  fmt [-check] <file>                             rewrite the state machine source in canonical form")
+	fmt.Fprintln(file, "  graph [-o <output>] <file>                      print a Graphviz graph")
+	fmt.Fprintln(file, "  version                                         print the version")
+	fmt.Fprintln(file, "")
+	fmt.Fprintln(file, "languages:")
+	for _, backend := range Backends {
+		fmt.Fprintf(file, "  %-8s %s\n", backend.Name, backend.Help)
+	}
+	fmt.Fprintln(file, "")
+	fmt.Fprintln(file, "smc <lang> [-o <output>] <file> is the same as smc gen -lang <lang>.")
+}
+
 func Main() {
+	var err = Run(os.Args[1:])
+	var usage *UsageError
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+	case errors.As(err, &usage):
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "run smc --help for usage")
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func Run(args []string) (err error) {
 	defer func() {
 		if msg := recover(); msg != nil {
-			fmt.Println(msg)
-			os.Exit(1)
+			err = fmt.Errorf("internal error: %v", msg)
 		}
 	}()
-	if len(os.Args) < 3 {
-		panic("usage: smc [cs|cpp|go] <file>")
+	if len(args) == 0 {
+		return &UsageError{Message: "missing command"}
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		Usage(os.Stdout)
+		return nil
+	case "gen":
+		return RunGen(args[1:])
+	case "check":
+		return RunCheck(args[1:])
+	case "fmt":
+		return RunFmt(args[1:])
+	case "graph":
+		return RunGraph(args[1:])
+	case "version", "-version", "--version":
+		fmt.Println("smc " + Version())
+		return nil
+	}
+	if FindBackend(args[0]) != nil {
+		return RunGen(append([]string{"-lang", args[0]}, args[1:]...))
+	}
+	var message = "unknown command " + args[0]
+	if similar := Similar(args[0], append(append([]string{}, Commands...), BackendNames()...)); similar != "" {
+		message += ", did you mean " + similar + "?"
+	}
+	return &UsageError{Message: message}
+}
+
+func ParseFlags(name string, args []string, setup func(flags *flag.FlagSet)) (string, error) {
+	var flags = flag.NewFlagSet("smc "+name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: smc %s [flags] <file>\n", name)
+		flags.PrintDefaults()
+	}
+	setup(flags)
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+		return "", err
+	} else if err != nil {
+		return "", &UsageError{Message: "smc " + name + ": " + err.Error()}
+	}
+	if flags.NArg() != 1 {
+		return "", &UsageError{Message: "smc " + name + ": expecting one input file"}
+	}
+	return flags.Arg(0), nil
+}
+
+func LoadRoots(filename, lang string) ([]*State, error) {
+	var texts, err = ReadRoots(filename)
+	if err != nil {
+		return nil, err
 	}
 	var (
-		root = Scan(strings.NewReader(ReadRoot(os.Args[2])))
-		src  = PrintRoot(root, "")
-		buf  = bytes.NewBuffer(nil)
+		roots   []*State
+		errors  ErrorList
+		machine = make(map[string]*State)
 	)
-	root.PushEvents()
-	if os.Args[1] == "cs" {
-		PrintCs(buf, root, src)
-		CheckWriteFile(os.Args[2], buf.Bytes())
+	for _, text := range texts {
+		var root, err = Parse(strings.NewReader(text), filename)
+		if err != nil {
+			errors.Add(err)
+			continue
+		}
+		if prev, found := machine[root.Name()]; found {
+			errors.Add(&Error{Pos: root.Pos(), Token: root.Name(), Message: "state machine " + root.Name() + " redeclared, previous declaration at " + prev.Pos().String()})
+			continue
+		}
+		if _, ns := SplitName(root.Name()); lang == "go" && len(roots) != 0 {
+			if _, first := SplitName(roots[0].Name()); strings.Join(ns, "") != strings.Join(first, "") {
+				errors.Add(&Error{Pos: root.Pos(), Token: root.Name(), Message: "state machine " + root.Name() + " must be in package " + strings.Join(first, "")})
+				continue
+			}
+		}
+		machine[root.Name()] = root
+		roots = append(roots, root)
+	}
+	return roots, errors.Err()
+}
+
+func RunGen(args []string) error {
+	var lang, output string
+	var check bool
+	var filename, err = ParseFlags("gen", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&lang, "lang", "", "target language: "+strings.Join(BackendNames(), ", "))
+		flags.StringVar(&output, "o", "", "output file, - for stdout")
+		flags.BoolVar(&check, "check", false, "print a diff and fail instead of writing when the output is out of date")
+		flags.BoolVar(&check, "dry-run", false, "same as -check")
+	})
+	if err != nil {
+		return err
+	}
+	if lang == "" {
+		return &UsageError{Message: "smc gen: missing -lang, expecting " + strings.Join(BackendNames(), ", ")}
+	}
+	var backend = FindBackend(lang)
+	if backend == nil {
+		var message = "unknown language " + lang
+		if similar := Similar(lang, BackendNames()); similar != "" {
+			message += ", did you mean " + similar + "?"
+		} else {
+			message += ", expecting " + strings.Join(BackendNames(), ", ")
+		}
+		return &UsageError{Message: message}
+	}
+	if output == "" {
+		output = OutputName(filename, lang)
+	}
+	if check && output == "-" {
+		return &UsageError{Message: "smc gen: -check cannot be used with -o -"}
+	}
+	roots, err := LoadRoots(filename, lang)
+	if err != nil {
+		return err
+	}
+	var outputs [][]byte
+	for _, root := range roots {
+		var (
+			src = PrintRoot(root, "")
+			buf = bytes.NewBuffer(nil)
+		)
+		root.PushEvents()
+		backend.Print(buf, root, src)
+		outputs = append(outputs, buf.Bytes())
 	}
-	if os.Args[1] == "cpp" {
-		PrintCpp(buf, root, src)
-		CheckWriteFile(os.Args[2], buf.Bytes())
+	if check {
+		return CompareFile(output, SplicedText(output, JoinOutputs(outputs)))
+	}
+	return WriteOutput(output, JoinOutputs(outputs))
+}
+
+func RunCheck(args []string) error {
+	var filename, err = ParseFlags("check", args, func(flags *flag.FlagSet) {})
+	if err != nil {
+		return err
+	}
+	roots, err := LoadRoots(filename, "")
+	if err != nil {
+		return err
+	}
+	var count = 0
+	for _, root := range roots {
+		root.PushEvents()
+		var warnings = Check(root)
+		for _, warning := range warnings {
+			fmt.Println(warning)
+		}
+		count += len(warnings)
+	}
+	if count != 0 {
+		return fmt.Errorf("%d warning(s)", count)
+	}
+	return nil
+}
+
+func RunFmt(args []string) error {
+	var check bool
+	var filename, err = ParseFlags("fmt", args, func(flags *flag.FlagSet) {
+		flags.BoolVar(&check, "check", false, "print a diff and fail instead of writing when the source is not formatted")
+		flags.BoolVar(&check, "dry-run", false, "same as -check")
+	})
+	if err != nil {
+		return err
+	}
+	roots, err := LoadRoots(filename, "")
+	if err != nil {
+		return err
+	}
+	text, err := FormatRoots(filename, roots)
+	if err != nil {
+		return err
+	}
+	if check {
+		return CompareFile(filename, text)
+	}
+	return WriteFile(filename, text)
+}
+
+func RunGraph(args []string) error {
+	var output string
+	var filename, err = ParseFlags("graph", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&output, "o", "-", "output file, - for stdout")
+	})
+	if err != nil {
+		return err
 	}
-	if os.Args[1] == "go" {
-		PrintGo(buf, root, src)
-		CheckWriteFile(os.Args[2], buf.Bytes())
+	roots, err := LoadRoots(filename, "")
+	if err != nil {
+		return err
 	}
-	if os.Args[1] == "lms-cs" {
-		PrintLmsCs(buf, root, src)
-		CheckWriteFile(os.Args[2], buf.Bytes())
+	var buf = bytes.NewBuffer(nil)
+	for _, root := range roots {
+		PrintDot(buf, root)
 	}
+	return WriteOutput(output, buf.Bytes())
 }
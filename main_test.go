@@ -0,0 +1,72 @@
+package smc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRoots(t *testing.T) {
+	var block = func(name string) string {
+		return "/**\n" + name + " {\n\tstart S;\n\tstate S;\n}\n**/\n\n"
+	}
+	var filename = filepath.Join(t.TempDir(), "test.go")
+	var write = func(text string) {
+		if err := os.WriteFile(filename, []byte(text), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("package x\n\n" + block("x.A") + block("x.B"))
+	var roots, err = LoadRoots(filename, "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outputs [][]byte
+	for _, root := range roots {
+		var buf = bytes.NewBuffer(nil)
+		root.PushEvents()
+		PrintGo(buf, root, PrintRoot(root, ""))
+		outputs = append(outputs, buf.Bytes())
+	}
+	var text = strings.ReplaceAll(string(JoinOutputs(outputs)), "\r\n", "\n")
+	if strings.Count(text, "package x\n") != 1 || strings.Contains(text, "type A struct {\n") == false || strings.Contains(text, "type B struct {\n") == false {
+		t.Errorf("expecting types A and B in one package, got\n%s", text)
+	}
+	write("package x\n\n" + block("x.A") + block("x.A") + block("y.C"))
+	_, err = LoadRoots(filename, "go")
+	var want = []string{
+		filename + ":11:1: state machine x.A redeclared, previous declaration at " + filename + ":4:1",
+		filename + ":18:1: state machine y.C must be in package x",
+	}
+	if err == nil || err.Error() != strings.Join(want, "\n") {
+		t.Errorf("expecting\n%s\ngot\n%v", strings.Join(want, "\n"), err)
+	}
+}
+
+func TestRunGenOutput(t *testing.T) {
+	var dir = t.TempDir()
+	var src = "x.M {\n\tstart A;\n\tstate A;\n}\n"
+	var filename = filepath.Join(dir, "door.sm")
+	if err := os.WriteFile(filename, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := Run([]string{"gen", "-lang", "go", filename}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Run([]string{"cpp", "-o", filepath.Join(dir, "out.hpp"), filename}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"door_sm.go", "out.hpp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expecting %s to be generated: %v", name, err)
+		}
+	}
+	if data, err := os.ReadFile(filename); err != nil || string(data) != src {
+		t.Errorf("expecting the source to be left unchanged, got %q %v", data, err)
+	}
+	if err := Run([]string{"gen", "-lang", "go", "-check", filename}); err != nil {
+		t.Errorf("expecting generated output to be up to date, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package smc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+type Param struct {
+	Name    string
+	Type    string
+	Literal bool
+}
+
+func JoinParams(params []Param, format string) string {
+	var list []string
+	for _, param := range params {
+		list = append(list, fmt.Sprintf(format, param.Name, param.Type))
+	}
+	return strings.Join(list, ", ")
+}
+
+func ArgParams(args []Param) []Param {
+	var list []Param
+	for idx, arg := range args {
+		if arg.Literal {
+			arg.Name = fmt.Sprintf("arg%d", idx+1)
+		}
+		list = append(list, arg)
+	}
+	return list
+}
+
+func JoinArgs(args []Param) string {
+	var list []string
+	for _, arg := range args {
+		if arg.Literal && arg.Type == "string" && strings.HasPrefix(arg.Name, "\"") == false {
+			list = append(list, strconv.Quote(arg.Name))
+		} else {
+			list = append(list, arg.Name)
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+func SameParams(a, b []Param) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	var text = func(param Param) bool {
+		var lower = strings.ToLower(param.Type)
+		return strings.Contains(lower, "string") || strings.Contains(lower, "char")
+	}
+	for idx := range a {
+		if a[idx].Literal != b[idx].Literal && text(a[idx]) && text(b[idx]) {
+			continue
+		}
+		if a[idx].Type != b[idx].Type {
+			return false
+		}
+	}
+	return true
+}
+
+func (root *State) EventParams(name string) []Param {
+	for _, state := range root.AllDescendants(root) {
+		for _, event := range state.Events() {
+			if event.Name() == name && len(event.Params()) != 0 {
+				return event.Params()
+			}
+		}
+	}
+	return nil
+}
+
+func (root *State) ActionParams(name string) []Param {
+	for _, state := range root.AllDescendants(root) {
+		for _, event := range state.Events() {
+			for idx, act := range event.Actions() {
+				if act == name && event.Args(idx) != nil {
+					return ArgParams(event.Args(idx))
+				}
+				if act == name {
+					return root.EventParams(event.Name())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (root *State) CondParams(name string) []Param {
+	for _, state := range root.AllDescendants(root) {
+		for _, event := range state.Events() {
+			if event.HasCond() {
+				for _, cond := range event.Cond().Names() {
+					if cond == name {
+						return root.EventParams(event.Name())
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func CheckParams(root *State) ErrorList {
+	type use struct {
+		pos    scanner.Position
+		params []Param
+	}
+	var (
+		errors ErrorList
+		events = make(map[string]*Event)
+		uses   = make(map[string]use)
+	)
+	var check = func(kind, name string, pos scanner.Position, params []Param) {
+		var key = kind + " " + name
+		if prev, found := uses[key]; found == false {
+			uses[key] = use{pos, params}
+		} else if SameParams(prev.params, params) == false {
+			errors = append(errors, &Error{Pos: pos, Token: name, Message: fmt.Sprintf("%s used with parameters (%s), previous use at %s with (%s)", key, JoinParams(params, "%[2]s"), prev.pos, JoinParams(prev.params, "%[2]s"))})
+		}
+	}
+	for _, state := range root.AllDescendants(root) {
+		for _, event := range state.Events() {
+			for _, param := range event.Params() {
+				if param.Type == "" {
+					errors = append(errors, &Error{Pos: event.Pos(), Token: param.Name, Message: "parameter " + param.Name + " of event " + event.Name() + " has no type"})
+				}
+			}
+			if len(event.Params()) == 0 {
+				continue
+			}
+			if prev, found := events[event.Name()]; found == false {
+				events[event.Name()] = event
+			} else if fmt.Sprint(prev.Params()) != fmt.Sprint(event.Params()) {
+				errors = append(errors, &Error{Pos: event.Pos(), Token: event.Name(), Message: "event " + event.Name() + " redeclared with different parameters, previous declaration at " + prev.Pos().String()})
+			}
+		}
+	}
+	for _, state := range root.AllDescendants(root) {
+		for _, act := range state.Entry() {
+			check("action", act, state.Pos(), nil)
+		}
+		for _, act := range state.Exit() {
+			check("action", act, state.Pos(), nil)
+		}
+		for _, event := range state.Events() {
+			var params = root.EventParams(event.Name())
+			for idx, act := range event.Actions() {
+				if event.Args(idx) != nil {
+					check("action", act, event.Pos(), ArgParams(event.Args(idx)))
+				} else {
+					check("action", act, event.Pos(), params)
+				}
+			}
+			if event.HasCond() {
+				for _, cond := range event.Cond().Names() {
+					check("condition", cond, event.Pos(), params)
+				}
+			}
+		}
+	}
+	return errors
+}
@@ -0,0 +1,31 @@
+package smc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckParams(t *testing.T) {
+	var tests = []struct {
+		src, message string
+	}{
+		{
+			"x.M {\n\tstart A;\n\tstate A {\n\t\tevent Go { act SetLed(Red); }\n\t\tevent Stop { act SetLed(1, 2); }\n\t}\n}",
+			"test.sm:5:9: action SetLed used with parameters (int, int), previous use at test.sm:4:9 with (string)",
+		},
+		{
+			"x.M {\n\tstart A;\n\tstate A {\n\t\tevent Go(n int) { act Log; }\n\t\tevent Stop { act Log(\"stop\"); }\n\t}\n}",
+			"test.sm:5:9: action Log used with parameters (string), previous use at test.sm:4:9 with (int)",
+		},
+		{
+			"x.M {\n\tstart A;\n\tstate A {\n\t\tevent Go(text const char *) { act Log; }\n\t\tevent Stop { act Log(\"stop\"); }\n\t}\n}",
+			"",
+		},
+	}
+	for _, test := range tests {
+		var _, err = Parse(strings.NewReader(test.src), "test.sm")
+		if test.message == "" && err != nil || test.message != "" && (err == nil || err.Error() != test.message) {
+			t.Errorf("%q: expecting %q, got %v", test.src, test.message, err)
+		}
+	}
+}
@@ -3,118 +3,364 @@ package smc
 /**
 smc.Parser {
 	start RootBegin;
-	state RootBegin {
-		event Next if Ident { dst RootNext; act RootBegin; }
+	state {
+		state RootBegin {
+			event Next if Ident { dst RootNext; act RootBegin; }
+		}
+		state RootNext {
+			event Next if Bra { dst StateNext; }
+			event Next if Dot { dst RootName; }
+		}
+		state RootName {
+			event Next if Ident { dst RootNext; act RootName; }
+		}
+		state RootRecover {
+			event Next if Bra { dst StateNext; act RootRecover; }
+			event Next;
+		}
+		event Next { dst RootRecover; act ErrorUnexpected; }
 	}
-	state RootNext {
-		event Next if Bra { dst StateNext; }
-		event Next if Dot { dst RootName; }
+	state {
+		state StateEntry {
+			event Next if Ident { dst StateEntryNext; act StateEntry; }
+		}
+		state StateExit {
+			event Next if Ident { dst StateExitNext; act StateExit; }
+		}
+		state StateStart {
+			event Next if Ident { dst StateStartNext; act StateStart; }
+		}
+		state StateDefer {
+			event Next if Ident { dst StateDeferNext; act StateDefer; }
+		}
+		state {
+			state StateStartNext;
+			state StateEntryNext {
+				event Next if Comma { dst StateEntry; }
+			}
+			state StateExitNext {
+				event Next if Comma { dst StateExit; }
+			}
+			state StateDeferNext {
+				event Next if Comma { dst StateDefer; }
+			}
+			state StateNext {
+				event Next if Actions { dst DeclBegin; act DeclActions; }
+				event Next if Conditions { dst DeclBegin; act DeclConditions; }
+				event Next if Defer { dst StateDefer; }
+				event Next if Entry { dst StateEntry; }
+				event Next if Event { dst EventName; act EventBegin; }
+				event Next if Events { dst DeclBegin; act DeclEvents; }
+				event Next if Exit { dst StateExit; }
+				event Next if Region { dst StateName; act StateBegin, StateRegion; }
+				event Next if Final { dst StateName; act StateBegin, StateFinal; }
+				event Next if Include { dst IncludeName; }
+				event Next if Template { dst TemplateName; }
+				event Next if Use { dst UseName; }
+				event Next if Choice { dst StateName; act StateBegin, StateChoice; }
+				event Next if If { dst EventCond; act EventBegin, EventBranch; }
+				event Next if Else { dst EventElseNext; act EventBegin, EventBranch, EventElse; }
+				event Next if Start { dst StateStart; }
+				event Next if State { dst StateName; act StateBegin; }
+			}
+			event Next if Semi { dst StateNext; }
+			event Next if Ket { dst StateNext; act StateEnd; }
+		}
+		state StateRecover {
+			event Next if Semi { dst StateNext; }
+			event Next if Ket { dst StateNext; act StateEnd; }
+			event Next if Bra { dst StateNext; act StateBegin; }
+			event Next;
+		}
+		event Next { dst StateRecover; act ErrorUnexpected; }
 	}
-	state RootName {
-		event Next if Ident { dst RootNext; act RootName; }
+	state {
+		state DeclBegin {
+			event Next if Bra { dst DeclName; }
+		}
+		state DeclName {
+			event Next if Ident { dst DeclNext; act DeclName; }
+			event Next if Ket { dst StateNext; }
+		}
+		state DeclNext {
+			event Next if Comma { dst DeclName; }
+			event Next if Ket { dst StateNext; }
+		}
+		state DeclRecover {
+			event Next if Ket { dst StateNext; }
+			event Next;
+		}
+		event Next { dst DeclRecover; act ErrorUnexpected; }
 	}
-	state StateEntry {
-		event Next if Ident { dst StateEntryNext; act StateEntry; }
+	state {
+		state IncludeName {
+			event Next if String { dst IncludeNext; act IncludeName; }
+		}
+		state IncludeNext {
+			event Next if Semi { dst StateNext; act Include; }
+		}
+		event Next { dst StateRecover; act ErrorUnexpected; }
 	}
-	state StateExit {
-		event Next if Ident { dst StateExitNext; act StateExit; }
+	state {
+		state TemplateName {
+			event Next if Ident { dst TemplateOpen; act TemplateBegin; }
+		}
+		state TemplateOpen {
+			event Next if Open { dst TemplateParam; }
+		}
+		state TemplateParam {
+			event Next if Ident { dst TemplateParamNext; act TemplateParam; }
+			event Next if Close { dst TemplateBody; }
+		}
+		state TemplateParamNext {
+			event Next if Comma { dst TemplateParam; }
+			event Next if Close { dst TemplateBody; }
+		}
+		state TemplateBody {
+			event Next if Bra { dst StateNext; act TemplateBody; }
+		}
+		event Next { dst StateRecover; act ErrorUnexpected; }
 	}
-	state StateStart {
-		event Next if Ident { dst StateStartNext; act StateStart; }
+	state {
+		state UseName {
+			event Next if Ident { dst UseOpen; act UseName; }
+		}
+		state UseOpen {
+			event Next if Open { dst UseArg; }
+		}
+		state UseArg {
+			event Next if Ident { dst UseArgNext; act UseArg; }
+			event Next if Close { dst UseAs; }
+		}
+		state UseArgNext {
+			event Next if Comma { dst UseArg; }
+			event Next if Close { dst UseAs; }
+		}
+		state UseAs {
+			event Next if As { dst UsePrefix; }
+		}
+		state UsePrefix {
+			event Next if Ident { dst UseNext; act UsePrefix; }
+		}
+		state UseNext {
+			event Next if Semi { dst StateNext; act Use; }
+		}
+		event Next { dst StateRecover; act ErrorUnexpected; }
 	}
 	state {
 		state StateName {
 			event Next if Ident { dst StateNameNext; act StateName; }
 		}
 		state StateNameNext;
+		state StateNameRecover {
+			event Next if Ket { dst StateNext; act StateEnd, StateEnd; }
+			event Next;
+		}
 		event Next if Semi { dst StateNext; act StateEnd; }
 		event Next if Bra { dst StateNext; }
+		event Next { dst StateNameRecover; act ErrorUnexpected; }
 	}
 	state {
-		state StateStartNext;
-		state StateEntryNext {
-			event Next if Comma { dst StateEntry; }
-		}
-		state StateExitNext {
-			event Next if Comma { dst StateExit; }
-		}
-		state StateNext {
-			event Next if Entry { dst StateEntry; }
-			event Next if Event { dst EventName; act EventBegin; }
-			event Next if Exit { dst StateExit; }
-			event Next if Start { dst StateStart; }
-			event Next if State { dst StateName; act StateBegin; }
-		}
-		event Next if Semi { dst StateNext; }
-		event Next if Ket { dst StateNext; act StateEnd; }
-	}
-	state EventName {
-		event Next if Ident { dst EventNameNext; act EventName; }
-	}
-	state EventCond {
-		event Next if Ident { dst EventCondNext; act EventCond; }
-	}
-	state EventAct {
-		event Next if Ident { dst EventActNext; act EventAct; }
-	}
-	state EventDst {
-		event Next if Ident { dst EventDstNext; act EventDst; }
-	}
-	state {
-		state EventNameNext {
-			event Next if If { dst EventCond; }
+		state EventName {
+			event Next if After { dst EventAfter; act EventAfter; }
+			event Next if Ident { dst EventNameNext; act EventName; }
+			event Next if Star { dst EventNameNext; act EventName; }
+		}
+		state EventAfter {
+			event Next if Int { dst EventAfterUnit; act EventAfterValue; }
+		}
+		state EventAfterUnit {
+			event Next if Ident { dst EventParamsNext; act EventAfterUnit; }
+		}
+		state EventCond {
+			event Next if Ident { dst EventCondNext; act EventCond; }
+			event Next if Not { act EventCondNot; }
+			event Next if Open { act EventCondOpen; }
+		}
+		state EventParam {
+			event Next if Ident { dst EventParamType; act EventParamName; }
+			event Next if Close { dst EventParamsNext; }
 		}
-		state EventCondNext;
-		event Next if Semi { dst StateNext; act EventEnd; }
-		event Next if Bra { dst EventNext; }
+		state EventParamType {
+			event Next if ParamNext { dst EventParam; }
+			event Next if ParamEnd { dst EventParamsNext; }
+			event Next if Semi { dst EventNameRecover; act ErrorUnexpected; }
+			event Next { act EventParamType; }
+		}
+		state {
+			state EventNameNext {
+				event Next if If { dst EventCond; }
+				event Next if Else { dst EventElseNext; act EventElse; }
+				event Next if Open { dst EventParam; }
+			}
+			state EventParamsNext {
+				event Next if If { dst EventCond; }
+				event Next if Else { dst EventElseNext; act EventElse; }
+			}
+			state EventElseNext;
+			state EventCondNext {
+				event Next if And { dst EventCond; act EventCondAnd; }
+				event Next if Or { dst EventCond; act EventCondOr; }
+				event Next if Close { act EventCondClose; }
+				event Next if Semi { dst StateNext; act EventCondEnd, EventEnd; }
+				event Next if Bra { dst EventNext; act EventCondEnd; }
+			}
+			event Next if Semi { dst StateNext; act EventEnd; }
+			event Next if Bra { dst EventNext; }
+		}
+		state EventNameRecover {
+			event Next if Semi { dst StateNext; act EventEnd; }
+			event Next if Bra { dst EventNext; }
+			event Next if Ket { dst StateNext; act EventEnd, StateEnd; }
+			event Next;
+		}
+		event Next { dst EventNameRecover; act ErrorUnexpected; }
 	}
 	state {
-		state EventDstNext;
-		state EventActNext {
-			event Next if Comma { dst EventAct; }
+		state EventAct {
+			event Next if Ident { dst EventActNext; act EventAct; }
+		}
+		state EventArg {
+			event Next if Ident { dst EventArgNext; act EventArg; }
+			event Next if Int { dst EventArgNext; act EventArg; }
+			event Next if String { dst EventArgNext; act EventArg; }
+			event Next if Close { dst EventActNext; }
+		}
+		state EventArgNext {
+			event Next if Comma { dst EventArg; }
+			event Next if Close { dst EventActNext; }
+		}
+		state EventDst {
+			event Next if Ident { dst EventDstNext; act EventDst; }
+			event Next if Not { dst EventExternal; act EventExternal; }
+		}
+		state EventExternal {
+			event Next if Ident { dst EventDstNext; act EventDst; }
+		}
+		state EventHistory {
+			event Next if History { dst EventHistoryNext; act EventHistory; }
 		}
-		state EventNext {
-			event Next if Act { dst EventAct; }
-			event Next if Dst { dst EventDst; }
+		state {
+			state EventDstNext {
+				event Next if Dot { dst EventHistory; }
+			}
+			state EventHistoryNext {
+				event Next if Star { dst EventDeepNext; act EventDeepHistory; }
+			}
+			state EventDeepNext;
+			state EventActNext {
+				event Next if Comma { dst EventAct; }
+				event Next if Open { dst EventArg; act EventArgs; }
+			}
+			state EventNext {
+				event Next if Act { dst EventAct; }
+				event Next if Dst { dst EventDst; }
+			}
+			event Next if Semi { dst EventNext; }
+			event Next if Ket { dst StateNext; act EventEnd; }
 		}
-		event Next if Semi { dst EventNext; }
-		event Next if Ket { dst StateNext; act EventEnd; }
+		state EventRecover {
+			event Next if Semi { dst EventNext; }
+			event Next if Ket { dst StateNext; act EventEnd; }
+			event Next;
+		}
+		event Next { dst EventRecover; act ErrorUnexpected; }
 	}
-	event Next { act ErrorUnexpected; }
 }
 **/
 
 type Parser struct {
-	OnErrorUnexpected func()
-	OnEventAct        func()
-	OnEventBegin      func()
-	OnEventCond       func()
-	OnEventDst        func()
-	OnEventEnd        func()
-	OnEventName       func()
-	OnRootBegin       func()
-	OnRootName        func()
-	OnStateBegin      func()
-	OnStateEnd        func()
-	OnStateEntry      func()
-	OnStateExit       func()
-	OnStateName       func()
-	OnStateStart      func()
-	CondAct           func() bool
-	CondBra           func() bool
-	CondComma         func() bool
-	CondDot           func() bool
-	CondDst           func() bool
-	CondEntry         func() bool
-	CondEvent         func() bool
-	CondExit          func() bool
-	CondIdent         func() bool
-	CondIf            func() bool
-	CondKet           func() bool
-	CondSemi          func() bool
-	CondStart         func() bool
-	CondState         func() bool
-	currentState      string
+	OnDeclActions      func()
+	OnDeclConditions   func()
+	OnDeclEvents       func()
+	OnDeclName         func()
+	OnErrorUnexpected  func()
+	OnEventAct         func()
+	OnEventAfter       func()
+	OnEventAfterUnit   func()
+	OnEventAfterValue  func()
+	OnEventArg         func()
+	OnEventArgs        func()
+	OnEventBegin       func()
+	OnEventBranch      func()
+	OnEventCond        func()
+	OnEventCondAnd     func()
+	OnEventCondClose   func()
+	OnEventCondEnd     func()
+	OnEventCondNot     func()
+	OnEventCondOpen    func()
+	OnEventCondOr      func()
+	OnEventDeepHistory func()
+	OnEventDst         func()
+	OnEventElse        func()
+	OnEventEnd         func()
+	OnEventExternal    func()
+	OnEventHistory     func()
+	OnEventName        func()
+	OnEventParamName   func()
+	OnEventParamType   func()
+	OnInclude          func()
+	OnIncludeName      func()
+	OnRootBegin        func()
+	OnRootName         func()
+	OnRootRecover      func()
+	OnStateBegin       func()
+	OnStateChoice      func()
+	OnStateDefer       func()
+	OnStateEnd         func()
+	OnStateEntry       func()
+	OnStateExit        func()
+	OnStateFinal       func()
+	OnStateName        func()
+	OnStateRegion      func()
+	OnStateStart       func()
+	OnTemplateBegin    func()
+	OnTemplateBody     func()
+	OnTemplateParam    func()
+	OnUse              func()
+	OnUseArg           func()
+	OnUseName          func()
+	OnUsePrefix        func()
+	CondAct            func() bool
+	CondActions        func() bool
+	CondAfter          func() bool
+	CondAnd            func() bool
+	CondAs             func() bool
+	CondBra            func() bool
+	CondChoice         func() bool
+	CondClose          func() bool
+	CondComma          func() bool
+	CondConditions     func() bool
+	CondDefer          func() bool
+	CondDot            func() bool
+	CondDst            func() bool
+	CondElse           func() bool
+	CondEntry          func() bool
+	CondEvent          func() bool
+	CondEvents         func() bool
+	CondExit           func() bool
+	CondFinal          func() bool
+	CondHistory        func() bool
+	CondIdent          func() bool
+	CondIf             func() bool
+	CondInclude        func() bool
+	CondInt            func() bool
+	CondKet            func() bool
+	CondNot            func() bool
+	CondOpen           func() bool
+	CondOr             func() bool
+	CondParamEnd       func() bool
+	CondParamNext      func() bool
+	CondRegion         func() bool
+	CondSemi           func() bool
+	CondStar           func() bool
+	CondStart          func() bool
+	CondState          func() bool
+	CondString         func() bool
+	CondTemplate       func() bool
+	CondUse            func() bool
+	OnUnhandled        func(name string)
+	currentState       string
 }
 
 func (this *Parser) SendNext() {
@@ -126,7 +372,9 @@ func (this *Parser) SendNext() {
 			this.currentState = "RootNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "RootRecover"
 	case "RootNext":
 		if this.CondBra() {
 			this.currentState = "StateNext"
@@ -136,7 +384,9 @@ func (this *Parser) SendNext() {
 			this.currentState = "RootName"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "RootRecover"
 	case "RootName":
 		if this.CondIdent() {
 			this.currentState = "none"
@@ -144,7 +394,16 @@ func (this *Parser) SendNext() {
 			this.currentState = "RootNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "RootRecover"
+	case "RootRecover":
+		if this.CondBra() {
+			this.currentState = "none"
+			this.OnRootRecover()
+			this.currentState = "StateNext"
+			return
+		}
 	case "StateEntry":
 		if this.CondIdent() {
 			this.currentState = "none"
@@ -152,7 +411,9 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateEntryNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
 	case "StateExit":
 		if this.CondIdent() {
 			this.currentState = "none"
@@ -160,7 +421,9 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateExitNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
 	case "StateStart":
 		if this.CondIdent() {
 			this.currentState = "none"
@@ -168,38 +431,38 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateStartNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
-	case "StateName":
+		this.currentState = "StateRecover"
+	case "StateDefer":
 		if this.CondIdent() {
 			this.currentState = "none"
-			this.OnStateName()
-			this.currentState = "StateNameNext"
+			this.OnStateDefer()
+			this.currentState = "StateDeferNext"
 			return
 		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "StateStartNext":
 		if this.CondSemi() {
-			this.currentState = "none"
-			this.OnStateEnd()
 			this.currentState = "StateNext"
 			return
 		}
-		if this.CondBra() {
-			this.currentState = "StateNext"
-			return
-		}
-		this.OnErrorUnexpected()
-	case "StateNameNext":
-		if this.CondSemi() {
+		if this.CondKet() {
 			this.currentState = "none"
 			this.OnStateEnd()
 			this.currentState = "StateNext"
 			return
 		}
-		if this.CondBra() {
-			this.currentState = "StateNext"
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "StateEntryNext":
+		if this.CondComma() {
+			this.currentState = "StateEntry"
 			return
 		}
-		this.OnErrorUnexpected()
-	case "StateStartNext":
 		if this.CondSemi() {
 			this.currentState = "StateNext"
 			return
@@ -210,10 +473,12 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
-	case "StateEntryNext":
+		this.currentState = "StateRecover"
+	case "StateExitNext":
 		if this.CondComma() {
-			this.currentState = "StateEntry"
+			this.currentState = "StateExit"
 			return
 		}
 		if this.CondSemi() {
@@ -226,10 +491,12 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
-	case "StateExitNext":
+		this.currentState = "StateRecover"
+	case "StateDeferNext":
 		if this.CondComma() {
-			this.currentState = "StateExit"
+			this.currentState = "StateDefer"
 			return
 		}
 		if this.CondSemi() {
@@ -242,8 +509,26 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
 	case "StateNext":
+		if this.CondActions() {
+			this.currentState = "none"
+			this.OnDeclActions()
+			this.currentState = "DeclBegin"
+			return
+		}
+		if this.CondConditions() {
+			this.currentState = "none"
+			this.OnDeclConditions()
+			this.currentState = "DeclBegin"
+			return
+		}
+		if this.CondDefer() {
+			this.currentState = "StateDefer"
+			return
+		}
 		if this.CondEntry() {
 			this.currentState = "StateEntry"
 			return
@@ -254,10 +539,64 @@ func (this *Parser) SendNext() {
 			this.currentState = "EventName"
 			return
 		}
+		if this.CondEvents() {
+			this.currentState = "none"
+			this.OnDeclEvents()
+			this.currentState = "DeclBegin"
+			return
+		}
 		if this.CondExit() {
 			this.currentState = "StateExit"
 			return
 		}
+		if this.CondRegion() {
+			this.currentState = "none"
+			this.OnStateBegin()
+			this.OnStateRegion()
+			this.currentState = "StateName"
+			return
+		}
+		if this.CondFinal() {
+			this.currentState = "none"
+			this.OnStateBegin()
+			this.OnStateFinal()
+			this.currentState = "StateName"
+			return
+		}
+		if this.CondInclude() {
+			this.currentState = "IncludeName"
+			return
+		}
+		if this.CondTemplate() {
+			this.currentState = "TemplateName"
+			return
+		}
+		if this.CondUse() {
+			this.currentState = "UseName"
+			return
+		}
+		if this.CondChoice() {
+			this.currentState = "none"
+			this.OnStateBegin()
+			this.OnStateChoice()
+			this.currentState = "StateName"
+			return
+		}
+		if this.CondIf() {
+			this.currentState = "none"
+			this.OnEventBegin()
+			this.OnEventBranch()
+			this.currentState = "EventCond"
+			return
+		}
+		if this.CondElse() {
+			this.currentState = "none"
+			this.OnEventBegin()
+			this.OnEventBranch()
+			this.OnEventElse()
+			this.currentState = "EventElseNext"
+			return
+		}
 		if this.CondStart() {
 			this.currentState = "StateStart"
 			return
@@ -275,15 +614,305 @@ func (this *Parser) SendNext() {
 			this.OnStateEnd()
 			return
 		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "StateRecover":
+		if this.CondSemi() {
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondKet() {
+			this.currentState = "none"
+			this.OnStateEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondBra() {
+			this.currentState = "none"
+			this.OnStateBegin()
+			this.currentState = "StateNext"
+			return
+		}
+	case "DeclBegin":
+		if this.CondBra() {
+			this.currentState = "DeclName"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "DeclRecover"
+	case "DeclName":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnDeclName()
+			this.currentState = "DeclNext"
+			return
+		}
+		if this.CondKet() {
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "DeclRecover"
+	case "DeclNext":
+		if this.CondComma() {
+			this.currentState = "DeclName"
+			return
+		}
+		if this.CondKet() {
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "DeclRecover"
+	case "DeclRecover":
+		if this.CondKet() {
+			this.currentState = "StateNext"
+			return
+		}
+	case "IncludeName":
+		if this.CondString() {
+			this.currentState = "none"
+			this.OnIncludeName()
+			this.currentState = "IncludeNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "IncludeNext":
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnInclude()
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "TemplateName":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnTemplateBegin()
+			this.currentState = "TemplateOpen"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "TemplateOpen":
+		if this.CondOpen() {
+			this.currentState = "TemplateParam"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "TemplateParam":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnTemplateParam()
+			this.currentState = "TemplateParamNext"
+			return
+		}
+		if this.CondClose() {
+			this.currentState = "TemplateBody"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "TemplateParamNext":
+		if this.CondComma() {
+			this.currentState = "TemplateParam"
+			return
+		}
+		if this.CondClose() {
+			this.currentState = "TemplateBody"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "TemplateBody":
+		if this.CondBra() {
+			this.currentState = "none"
+			this.OnTemplateBody()
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "UseName":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnUseName()
+			this.currentState = "UseOpen"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "UseOpen":
+		if this.CondOpen() {
+			this.currentState = "UseArg"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "UseArg":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnUseArg()
+			this.currentState = "UseArgNext"
+			return
+		}
+		if this.CondClose() {
+			this.currentState = "UseAs"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "UseArgNext":
+		if this.CondComma() {
+			this.currentState = "UseArg"
+			return
+		}
+		if this.CondClose() {
+			this.currentState = "UseAs"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "UseAs":
+		if this.CondAs() {
+			this.currentState = "UsePrefix"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "UsePrefix":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnUsePrefix()
+			this.currentState = "UseNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "UseNext":
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnUse()
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateRecover"
+	case "StateName":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnStateName()
+			this.currentState = "StateNameNext"
+			return
+		}
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnStateEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondBra() {
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "StateNameRecover"
+	case "StateNameNext":
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnStateEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondBra() {
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "StateNameRecover"
+	case "StateNameRecover":
+		if this.CondKet() {
+			this.currentState = "none"
+			this.OnStateEnd()
+			this.OnStateEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnStateEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondBra() {
+			this.currentState = "StateNext"
+			return
+		}
 	case "EventName":
+		if this.CondAfter() {
+			this.currentState = "none"
+			this.OnEventAfter()
+			this.currentState = "EventAfter"
+			return
+		}
 		if this.CondIdent() {
 			this.currentState = "none"
 			this.OnEventName()
 			this.currentState = "EventNameNext"
 			return
 		}
+		if this.CondStar() {
+			this.currentState = "none"
+			this.OnEventName()
+			this.currentState = "EventNameNext"
+			return
+		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "EventNameRecover"
+	case "EventAfter":
+		if this.CondInt() {
+			this.currentState = "none"
+			this.OnEventAfterValue()
+			this.currentState = "EventAfterUnit"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventNameRecover"
+	case "EventAfterUnit":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnEventAfterUnit()
+			this.currentState = "EventParamsNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventNameRecover"
 	case "EventCond":
 		if this.CondIdent() {
 			this.currentState = "none"
@@ -291,28 +920,86 @@ func (this *Parser) SendNext() {
 			this.currentState = "EventCondNext"
 			return
 		}
+		if this.CondNot() {
+			this.OnEventCondNot()
+			return
+		}
+		if this.CondOpen() {
+			this.OnEventCondOpen()
+			return
+		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
-	case "EventAct":
+		this.currentState = "EventNameRecover"
+	case "EventParam":
 		if this.CondIdent() {
 			this.currentState = "none"
-			this.OnEventAct()
-			this.currentState = "EventActNext"
+			this.OnEventParamName()
+			this.currentState = "EventParamType"
+			return
+		}
+		if this.CondClose() {
+			this.currentState = "EventParamsNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
-	case "EventDst":
-		if this.CondIdent() {
+		this.currentState = "EventNameRecover"
+	case "EventParamType":
+		if this.CondParamNext() {
+			this.currentState = "EventParam"
+			return
+		}
+		if this.CondParamEnd() {
+			this.currentState = "EventParamsNext"
+			return
+		}
+		if this.CondSemi() {
 			this.currentState = "none"
-			this.OnEventDst()
-			this.currentState = "EventDstNext"
+			this.OnErrorUnexpected()
+			this.currentState = "EventNameRecover"
 			return
 		}
-		this.OnErrorUnexpected()
+		this.OnEventParamType()
 	case "EventNameNext":
 		if this.CondIf() {
 			this.currentState = "EventCond"
 			return
 		}
+		if this.CondElse() {
+			this.currentState = "none"
+			this.OnEventElse()
+			this.currentState = "EventElseNext"
+			return
+		}
+		if this.CondOpen() {
+			this.currentState = "EventParam"
+			return
+		}
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnEventEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondBra() {
+			this.currentState = "EventNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventNameRecover"
+	case "EventParamsNext":
+		if this.CondIf() {
+			this.currentState = "EventCond"
+			return
+		}
+		if this.CondElse() {
+			this.currentState = "none"
+			this.OnEventElse()
+			this.currentState = "EventElseNext"
+			return
+		}
 		if this.CondSemi() {
 			this.currentState = "none"
 			this.OnEventEnd()
@@ -323,8 +1010,57 @@ func (this *Parser) SendNext() {
 			this.currentState = "EventNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "EventNameRecover"
+	case "EventElseNext":
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnEventEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondBra() {
+			this.currentState = "EventNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventNameRecover"
 	case "EventCondNext":
+		if this.CondAnd() {
+			this.currentState = "none"
+			this.OnEventCondAnd()
+			this.currentState = "EventCond"
+			return
+		}
+		if this.CondOr() {
+			this.currentState = "none"
+			this.OnEventCondOr()
+			this.currentState = "EventCond"
+			return
+		}
+		if this.CondClose() {
+			this.OnEventCondClose()
+			return
+		}
+		if this.CondSemi() {
+			this.currentState = "none"
+			this.OnEventCondEnd()
+			this.OnEventEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		if this.CondBra() {
+			this.currentState = "none"
+			this.OnEventCondEnd()
+			this.currentState = "EventNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventNameRecover"
+	case "EventNameRecover":
 		if this.CondSemi() {
 			this.currentState = "none"
 			this.OnEventEnd()
@@ -335,8 +1071,136 @@ func (this *Parser) SendNext() {
 			this.currentState = "EventNext"
 			return
 		}
+		if this.CondKet() {
+			this.currentState = "none"
+			this.OnEventEnd()
+			this.OnStateEnd()
+			this.currentState = "StateNext"
+			return
+		}
+	case "EventAct":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnEventAct()
+			this.currentState = "EventActNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventArg":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnEventArg()
+			this.currentState = "EventArgNext"
+			return
+		}
+		if this.CondInt() {
+			this.currentState = "none"
+			this.OnEventArg()
+			this.currentState = "EventArgNext"
+			return
+		}
+		if this.CondString() {
+			this.currentState = "none"
+			this.OnEventArg()
+			this.currentState = "EventArgNext"
+			return
+		}
+		if this.CondClose() {
+			this.currentState = "EventActNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventArgNext":
+		if this.CondComma() {
+			this.currentState = "EventArg"
+			return
+		}
+		if this.CondClose() {
+			this.currentState = "EventActNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventDst":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnEventDst()
+			this.currentState = "EventDstNext"
+			return
+		}
+		if this.CondNot() {
+			this.currentState = "none"
+			this.OnEventExternal()
+			this.currentState = "EventExternal"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventExternal":
+		if this.CondIdent() {
+			this.currentState = "none"
+			this.OnEventDst()
+			this.currentState = "EventDstNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventHistory":
+		if this.CondHistory() {
+			this.currentState = "none"
+			this.OnEventHistory()
+			this.currentState = "EventHistoryNext"
+			return
+		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
 	case "EventDstNext":
+		if this.CondDot() {
+			this.currentState = "EventHistory"
+			return
+		}
+		if this.CondSemi() {
+			this.currentState = "EventNext"
+			return
+		}
+		if this.CondKet() {
+			this.currentState = "none"
+			this.OnEventEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventHistoryNext":
+		if this.CondStar() {
+			this.currentState = "none"
+			this.OnEventDeepHistory()
+			this.currentState = "EventDeepNext"
+			return
+		}
+		if this.CondSemi() {
+			this.currentState = "EventNext"
+			return
+		}
+		if this.CondKet() {
+			this.currentState = "none"
+			this.OnEventEnd()
+			this.currentState = "StateNext"
+			return
+		}
+		this.currentState = "none"
+		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventDeepNext":
 		if this.CondSemi() {
 			this.currentState = "EventNext"
 			return
@@ -347,12 +1211,20 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
 	case "EventActNext":
 		if this.CondComma() {
 			this.currentState = "EventAct"
 			return
 		}
+		if this.CondOpen() {
+			this.currentState = "none"
+			this.OnEventArgs()
+			this.currentState = "EventArg"
+			return
+		}
 		if this.CondSemi() {
 			this.currentState = "EventNext"
 			return
@@ -363,7 +1235,9 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
 	case "EventNext":
 		if this.CondAct() {
 			this.currentState = "EventAct"
@@ -382,9 +1256,26 @@ func (this *Parser) SendNext() {
 			this.currentState = "StateNext"
 			return
 		}
+		this.currentState = "none"
 		this.OnErrorUnexpected()
+		this.currentState = "EventRecover"
+	case "EventRecover":
+		if this.CondSemi() {
+			this.currentState = "EventNext"
+			return
+		}
+		if this.CondKet() {
+			this.currentState = "none"
+			this.OnEventEnd()
+			this.currentState = "StateNext"
+			return
+		}
 	case "none":
 		panic("invalid state")
+	default:
+		if this.OnUnhandled != nil {
+			this.OnUnhandled("Next")
+		}
 	}
 }
 
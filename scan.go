@@ -1,150 +1,857 @@
 package smc
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"text/scanner"
+	"time"
+	"unicode/utf8"
 )
 
-func Scan(file io.Reader) *State {
+type reference struct {
+	pos  scanner.Position
+	bind func(*State)
+}
+
+type inclusion struct {
+	scan *scanner.Scanner
+	host *State
+	path string
+}
+
+type lexeme struct {
+	next rune
+	text string
+	pos  scanner.Position
+}
+
+type template struct {
+	state  *State
+	tokens []lexeme
+	names  map[string][]reference
+	depth  int
+}
+
+func named(tokens []lexeme, idx int) bool {
+	if tokens[idx].next != scanner.Ident || idx == 0 {
+		return false
+	}
+	switch tokens[idx-1].text {
+	case "state", "region", "final", "choice", "dst", "start", "as":
+		return true
+	case "!":
+		return idx > 1 && tokens[idx-2].text == "dst"
+	case "(", ",":
+		var open = idx - 1
+		for open > 1 && tokens[open].text == "," {
+			open -= 2
+		}
+		return open > 1 && tokens[open].text == "(" && tokens[open-2].text == "use"
+	}
+	return false
+}
+
+func Parse(file io.Reader, filename string) (*State, error) {
 	var (
-		root   *State
-		state  *State
-		event  *Event
-		parser *Parser
-		scan   scanner.Scanner
-		next   rune
-		names  = make(map[string][]func(*State))
+		root      *State
+		state     *State
+		event     *Event
+		parser    *Parser
+		scan      *scanner.Scanner
+		stack     []inclusion
+		include   string
+		includeAt scanner.Position
+		templ     *template
+		recording bool
+		use       []string
+		useAt     scanner.Position
+		next      rune
+		text      string
+		operands  []*Cond
+		operators []string
+		depth     int
+		tail      int
+		retry     bool
+		errors    ErrorList
+		expected  []string
+		origin, _ = filepath.Abs(filename)
+		names     = make(map[string][]reference)
+		started   = make(map[*State]bool)
+		templates = make(map[string]*template)
+		ignore    = make(map[scanner.Position][]string)
+		last      scanner.Position
+		kind      string
 	)
+	var ignored = func(pos scanner.Position) []string {
+		return ignore[scanner.Position{Filename: pos.Filename, Line: pos.Line}]
+	}
+	var fail = func(pos scanner.Position, token, message string) {
+		errors = append(errors, &Error{Pos: pos, Token: token, Message: message})
+	}
+	var open = func(file io.Reader, filename string) *scanner.Scanner {
+		var scan = new(scanner.Scanner)
+		scan.Init(file)
+		scan.Filename = filename
+		scan.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanStrings | scanner.ScanComments
+		scan.Error = func(_ *scanner.Scanner, message string) {
+			fail(scan.Pos(), scan.TokenText(), message)
+		}
+		return scan
+	}
+	var fragment = func() {
+		if len(stack) != 0 && state.spliced == false {
+			fail(scan.Position, text, "included files and templates can only declare states and events")
+		}
+	}
+	var declare = func(name string) func() {
+		return func() {
+			fragment()
+			if state.parent != nil {
+				fail(scan.Position, text, name+" must be declared at the top level")
+			}
+			if root.declared == nil {
+				root.declared = make(map[string][]string)
+			}
+			if _, found := root.declared[name]; found {
+				fail(scan.Position, text, name+" declared twice")
+			}
+			root.declared[name] = []string{}
+			kind = name
+		}
+	}
+	var token = func(str string) func() bool {
+		return func() bool {
+			expected = append(expected, str)
+			return text == str
+		}
+	}
+	var reduce = func() {
+		var op = operators[len(operators)-1]
+		operators = operators[:len(operators)-1]
+		if op == "!" {
+			var last = len(operands) - 1
+			operands[last] = &Cond{op: op, args: []*Cond{operands[last]}}
+		} else {
+			var last = len(operands) - 2
+			operands[last] = &Cond{op: op, args: []*Cond{operands[last], operands[last+1]}}
+			operands = operands[:last+1]
+		}
+	}
+	var unary = func() {
+		for len(operators) != 0 && operators[len(operators)-1] == "!" {
+			reduce()
+		}
+	}
+	var binary = func() {
+		var prec = (&Cond{op: text}).precedence()
+		for len(operators) != 0 && operators[len(operators)-1] != "(" {
+			if (&Cond{op: operators[len(operators)-1]}).precedence() < prec {
+				break
+			}
+			reduce()
+		}
+		operators = append(operators, text)
+	}
 	parser = &Parser{
 		OnErrorUnexpected: func() {
-			panic(scan.Pos().String() + ": unexpected " + scan.TokenText())
+			errors = append(errors, &Error{Pos: scan.Position, Token: text, Expected: StringSet(expected)})
+			retry = true
+		},
+		OnDeclActions:    declare("actions"),
+		OnDeclConditions: declare("conditions"),
+		OnDeclEvents:     declare("events"),
+		OnDeclName: func() {
+			for _, name := range root.declared[kind] {
+				if name == text {
+					fail(scan.Position, text, text+" redeclared in "+kind)
+					return
+				}
+			}
+			root.declared[kind] = append(root.declared[kind], text)
 		},
 		OnEventAct: func() {
-			event.act = append(event.act, scan.TokenText())
+			event.act = append(event.act, text)
+		},
+		OnEventArg: func() {
+			var arg = Param{Name: text, Type: "string", Literal: true}
+			switch next {
+			case scanner.Int:
+				arg.Type = "int"
+			case scanner.Ident:
+				for _, param := range event.params {
+					if param.Name == text {
+						arg = param
+					}
+				}
+			}
+			var last = len(event.act) - 1
+			event.args[last] = append(event.args[last], arg)
+		},
+		OnEventArgs: func() {
+			for len(event.args) < len(event.act) {
+				event.args = append(event.args, nil)
+			}
+			event.args[len(event.act)-1] = []Param{}
 		},
 		OnEventBegin: func() {
-			event = &Event{src: state}
+			event = &Event{src: state, pos: scan.Position, spliced: len(stack) != 0}
+			operands, operators = nil, nil
+		},
+		OnEventAfter: func() {
+			event.pos = scan.Position
+		},
+		OnEventAfterUnit: func() {
+			var units = map[string]time.Duration{"ms": time.Millisecond, "s": time.Second, "m": time.Minute, "h": time.Hour}
+			if unit, found := units[text]; found {
+				event.timeout *= unit
+			} else {
+				fail(scan.Position, text, "unknown time unit "+text+", expecting ms, s, m or h")
+			}
+			event.after += text
+			event.name = "After" + event.after
+		},
+		OnEventAfterValue: func() {
+			var value, _ = strconv.Atoi(text)
+			event.timeout = time.Duration(value)
+			event.after = text
+		},
+		OnEventBranch: func() {
+			event.branch = true
+			event.pos = scan.Position
 		},
 		OnEventCond: func() {
-			event.cond = scan.TokenText()
+			operands = append(operands, &Cond{name: text})
+			unary()
+		},
+		OnEventCondAnd: binary,
+		OnEventCondClose: func() {
+			for len(operators) != 0 && operators[len(operators)-1] != "(" {
+				reduce()
+			}
+			if len(operators) == 0 {
+				fail(scan.Position, text, "unexpected ), missing (")
+				return
+			}
+			operators = operators[:len(operators)-1]
+			unary()
+		},
+		OnEventCondEnd: func() {
+			for len(operators) != 0 {
+				if operators[len(operators)-1] == "(" {
+					fail(scan.Position, text, "unexpected "+text+", missing )")
+					return
+				}
+				reduce()
+			}
+			event.cond = operands[0]
+		},
+		OnEventCondNot: func() {
+			operators = append(operators, text)
+		},
+		OnEventCondOpen: func() {
+			operators = append(operators, text)
 		},
+		OnEventCondOr: binary,
 		OnEventDst: func() {
 			var this = event
-			var text = scan.TokenText()
-			names[text] = append(names[text], func(st *State) {
+			var name = text
+			names[name] = append(names[name], reference{scan.Position, func(st *State) {
 				this.dst = st
-			})
+			}})
+		},
+		OnEventDeepHistory: func() {
+			event.history = 2
+		},
+		OnEventExternal: func() {
+			event.external = true
+		},
+		OnEventHistory: func() {
+			event.history = 1
+		},
+		OnEventElse: func() {
+			event.fallback = true
 		},
 		OnEventEnd: func() {
-			if state.AddEvent(event) {
-				panic(scan.Pos().String() + ": event " + event.Name() + " redeclared")
+			if event.name == "" && event.branch == false {
+				return
+			}
+			if state.AddEvent(event) == false {
+				if event.IsTimer() && state.Timer(event.after) == nil {
+					state.timers = append(state.timers, event)
+				}
+			} else if event.branch {
+				fail(event.pos, state.name, "branch of choice "+state.name+" redeclared")
+			} else {
+				fail(event.pos, event.name, "event "+event.Name()+" redeclared")
+			}
+		},
+		OnEventParamName: func() {
+			event.params = append(event.params, Param{Name: text})
+			depth = 0
+		},
+		OnEventParamType: func() {
+			var param = &event.params[len(event.params)-1]
+			if param.Type != "" && scan.Offset > tail {
+				param.Type += " "
+			}
+			param.Type += text
+			tail = scan.Offset + len(text)
+			switch text {
+			case "(", "[", "{":
+				depth++
+			case "<":
+				if scan.Peek() != '-' {
+					depth++
+				}
+			case ")", "]", "}":
+				depth--
+			case ">":
+				if depth > 0 {
+					depth--
+				}
 			}
 		},
 		OnEventName: func() {
-			event.name = scan.TokenText()
+			event.name = text
+			event.pos = scan.Position
+		},
+		OnInclude: func() {
+			var path = include
+			if filepath.IsAbs(path) == false {
+				path = filepath.Join(filepath.Dir(scan.Filename), path)
+			}
+			var abs, _ = filepath.Abs(path)
+			var chain []string
+			var cycle = abs == origin
+			for _, inc := range stack {
+				chain = append(chain, inc.scan.Filename)
+				cycle = cycle || abs == inc.path
+			}
+			if cycle {
+				chain = append(chain, scan.Filename, path)
+				fail(includeAt, include, "include cycle "+strings.Join(chain, " -> "))
+				return
+			}
+			var data, err = os.ReadFile(path)
+			if err != nil {
+				fail(includeAt, include, "unable to read "+path)
+				return
+			}
+			if len(stack) == 0 {
+				state.include = append(state.include, include)
+			}
+			stack = append(stack, inclusion{scan: scan, host: state, path: abs})
+			scan = open(bytes.NewReader(data), path)
+		},
+		OnIncludeName: func() {
+			include, _ = strconv.Unquote(text)
+			includeAt = scan.Position
 		},
 		OnRootBegin: func() {
-			root = &State{name: scan.TokenText()}
+			root = &State{name: text, pos: scan.Position}
 			state = root
 		},
 		OnRootName: func() {
-			root.name += "." + scan.TokenText()
+			root.name += "." + text
 		},
-		OnStateBegin: func() {
-			if state == nil {
-				parser.OnErrorUnexpected()
+		OnRootRecover: func() {
+			if root == nil {
+				root = &State{pos: scan.Position}
+				state = root
 			}
-			state = &State{parent: state}
+		},
+		OnStateBegin: func() {
+			state = &State{parent: state, pos: scan.Position, spliced: len(stack) != 0}
 		},
 		OnStateEnd: func() {
-			if state == nil {
-				parser.OnErrorUnexpected()
+			if templ != nil && state == templ.state {
+				var declared = make(map[string]*State)
+				for _, st := range state.AllDescendants() {
+					declared[st.name] = st
+				}
+				for name, list := range names {
+					var st = declared[name]
+					if st == nil {
+						st = &State{name: name}
+					}
+					for _, ref := range list {
+						ref.bind(st)
+					}
+				}
+				if len(templ.tokens) != 0 {
+					templ.tokens = templ.tokens[:len(templ.tokens)-1]
+				} else {
+					fail(state.pos, state.name, "unterminated template "+state.name)
+				}
+				names = templ.names
+				root.templates = append(root.templates, state)
+				state, templ, recording = state.parent, nil, false
+				return
 			}
 			if state.parent != nil {
 				state.parent.AddState(state)
 			}
 			state = state.parent
 		},
+		OnStateDefer: func() {
+			fragment()
+			state.deferred = append(state.deferred, text)
+		},
 		OnStateEntry: func() {
-			state.entry = append(state.entry, scan.TokenText())
+			fragment()
+			state.entry = append(state.entry, text)
 		},
 		OnStateExit: func() {
-			state.exit = append(state.exit, scan.TokenText())
+			fragment()
+			state.exit = append(state.exit, text)
 		},
 		OnStateName: func() {
-			state.name = scan.TokenText()
+			state.name = text
+			state.pos = scan.Position
+		},
+		OnStateChoice: func() {
+			state.choice = true
+		},
+		OnStateFinal: func() {
+			state.final = true
+		},
+		OnStateRegion: func() {
+			state.region = true
 		},
 		OnStateStart: func() {
+			fragment()
 			var this = state
-			var text = scan.TokenText()
-			names[text] = append(names[text], func(st *State) {
+			started[state] = true
+			var name = text
+			names[name] = append(names[name], reference{scan.Position, func(st *State) {
 				this.start = st
-			})
+			}})
 		},
-		CondAct: func() bool {
-			return scan.TokenText() == "act"
-		},
-		CondBra: func() bool {
-			return scan.TokenText() == "{"
+		OnTemplateBegin: func() {
+			if state.parent != nil {
+				fail(scan.Position, text, "template must be declared at the top level")
+			}
+			if _, found := templates[text]; found {
+				fail(scan.Position, text, "template "+text+" redeclared")
+			}
+			templ = &template{names: names, depth: len(stack)}
+			names = make(map[string][]reference)
+			state = &State{parent: state, name: text, pos: scan.Position, template: true, spliced: len(stack) != 0}
+			templ.state = state
+			templates[text] = templ
 		},
-		CondComma: func() bool {
-			return scan.TokenText() == ","
+		OnTemplateBody: func() {
+			recording = true
 		},
-		CondDot: func() bool {
-			return scan.TokenText() == "."
+		OnTemplateParam: func() {
+			state.params = append(state.params, text)
 		},
-		CondDst: func() bool {
-			return scan.TokenText() == "dst"
+		OnUse: func() {
+			var name, args, prefix = use[0], use[1 : len(use)-1], use[len(use)-1]
+			var tmpl, found = templates[name]
+			if found == false {
+				var known []string
+				for name := range templates {
+					known = append(known, name)
+				}
+				var message = "unknown template " + name
+				if similar := Similar(name, known); similar != "" {
+					message += ", did you mean " + similar + "?"
+				}
+				fail(useAt, name, message)
+				return
+			}
+			if len(args) != len(tmpl.state.params) {
+				fail(useAt, name, fmt.Sprintf("template %s expects %d argument(s), got %d", name, len(tmpl.state.params), len(args)))
+				return
+			}
+			if tmpl == templ {
+				fail(useAt, name, "template "+name+" instantiates itself")
+				return
+			}
+			if len(stack) == 0 {
+				state.uses = append(state.uses, fmt.Sprintf("%s(%s) as %s", name, strings.Join(args, ", "), prefix))
+			}
+			if len(tmpl.tokens) == 0 {
+				return
+			}
+			var rename = make(map[string]string)
+			for _, st := range tmpl.state.AllDescendants() {
+				rename[st.name] = prefix + st.name
+			}
+			for idx, tok := range tmpl.tokens {
+				if idx != 0 && tok.next == scanner.Ident && tmpl.tokens[idx-1].text == "as" {
+					rename[tok.text] = prefix + tok.text
+				}
+			}
+			for idx, param := range tmpl.state.params {
+				rename[param] = args[idx]
+			}
+			var buf strings.Builder
+			var line, column, shift = 1, 1, 0
+			for idx, tok := range tmpl.tokens {
+				if tok.pos.Line > line {
+					buf.WriteString(strings.Repeat("\n", tok.pos.Line-line))
+					line, column, shift = tok.pos.Line, 1, 0
+				}
+				if pad := tok.pos.Column + shift - column; pad > 0 {
+					buf.WriteString(strings.Repeat(" ", pad))
+					column += pad
+				} else if pad < 0 {
+					buf.WriteString(" ")
+					column++
+				}
+				var str = tok.text
+				if to, found := rename[str]; found && named(tmpl.tokens, idx) {
+					str = to
+				}
+				shift += utf8.RuneCountInString(str) - utf8.RuneCountInString(tok.text)
+				column += utf8.RuneCountInString(str)
+				buf.WriteString(str)
+			}
+			stack = append(stack, inclusion{scan: scan, host: state})
+			scan = open(strings.NewReader(buf.String()), tmpl.tokens[0].pos.Filename)
 		},
-		CondEntry: func() bool {
-			return scan.TokenText() == "entry"
+		OnUseArg: func() {
+			use = append(use, text)
 		},
-		CondEvent: func() bool {
-			return scan.TokenText() == "event"
+		OnUseName: func() {
+			use = []string{text}
+			useAt = scan.Position
 		},
-		CondExit: func() bool {
-			return scan.TokenText() == "exit"
+		OnUsePrefix: func() {
+			use = append(use, text)
 		},
+		CondAct:        token("act"),
+		CondActions:    token("actions"),
+		CondAfter:      token("after"),
+		CondAnd:        token("&&"),
+		CondAs:         token("as"),
+		CondBra:        token("{"),
+		CondChoice:     token("choice"),
+		CondClose:      token(")"),
+		CondComma:      token(","),
+		CondConditions: token("conditions"),
+		CondDot:        token("."),
+		CondDefer:      token("defer"),
+		CondDst:        token("dst"),
+		CondElse:       token("else"),
+		CondEntry:      token("entry"),
+		CondEvent:      token("event"),
+		CondEvents:     token("events"),
+		CondExit:       token("exit"),
+		CondFinal:      token("final"),
 		CondIdent: func() bool {
+			expected = append(expected, "identifier")
 			return next == scanner.Ident
 		},
-		CondIf: func() bool {
-			return scan.TokenText() == "if"
+		CondHistory: token("history"),
+		CondIf:      token("if"),
+		CondInclude: token("include"),
+		CondInt: func() bool {
+			expected = append(expected, "number")
+			return next == scanner.Int
 		},
-		CondKet: func() bool {
-			return scan.TokenText() == "}"
+		CondNot:  token("!"),
+		CondOpen: token("("),
+		CondOr:   token("||"),
+		CondParamEnd: func() bool {
+			expected = append(expected, ")")
+			return text == ")" && depth == 0
 		},
-		CondSemi: func() bool {
-			return scan.TokenText() == ";"
+		CondParamNext: func() bool {
+			expected = append(expected, ",")
+			return text == "," && depth == 0
 		},
-		CondStart: func() bool {
-			return scan.TokenText() == "start"
-		},
-		CondState: func() bool {
-			return scan.TokenText() == "state"
+		CondKet:    token("}"),
+		CondRegion: token("region"),
+		CondSemi:   token(";"),
+		CondStar:   token("*"),
+		CondStart:  token("start"),
+		CondState:  token("state"),
+		CondString: func() bool {
+			expected = append(expected, "string")
+			return next == scanner.String
 		},
+		CondTemplate: token("template"),
+		CondUse:      token("use"),
 	}
 	parser.Start()
-	scan.Init(file)
-	scan.Mode = scanner.ScanIdents | scanner.ScanComments | scanner.SkipComments
-	for next = scan.Scan(); next != scanner.EOF; next = scan.Scan() {
+	scan = open(file, filename)
+	for {
+		if next = scan.Scan(); next == scanner.EOF {
+			if len(stack) == 0 {
+				break
+			}
+			var top = stack[len(stack)-1]
+			if state != top.host {
+				fail(scan.Pos(), "EOF", "unbalanced braces in spliced file "+scan.Filename)
+			}
+			scan, stack = top.scan, stack[:len(stack)-1]
+			continue
+		}
+		if next == scanner.Comment {
+			if kinds, ok := Directive(scan.TokenText()); ok {
+				var line = scanner.Position{Filename: scan.Filename, Line: scan.Line}
+				if last.Line != scan.Line || last.Filename != scan.Filename {
+					line.Line++
+				}
+				ignore[line] = append(ignore[line], kinds...)
+			}
+			continue
+		}
+		last = scan.Position
+		text = scan.TokenText()
+		if (text == "&" || text == "|") && scan.Peek() == next {
+			text += string(scan.Next())
+		}
+		if root != nil && state == nil {
+			errors = append(errors, &Error{Pos: scan.Position, Token: text, Expected: []string{"EOF"}})
+			break
+		}
+		if recording && len(stack) == templ.depth {
+			templ.tokens = append(templ.tokens, lexeme{next, text, scan.Position})
+		}
+		expected = nil
 		parser.SendNext()
+		if retry {
+			retry = false
+			parser.SendNext()
+		}
 	}
-	if state != nil || root == nil {
-		panic(scan.Pos().String() + ": unexpected EOF")
+	if root == nil || state != nil {
+		errors = append(errors, &Error{Pos: scan.Pos(), Token: "EOF"})
+	}
+	if root == nil {
+		return nil, errors.Err()
 	}
 	for _, st := range root.AllDescendants(root) {
+		st.ignore = ignored(st.pos)
+		for _, ev := range st.events {
+			ev.ignore = ignored(ev.pos)
+		}
+	}
+	var timers = make(map[string]*Event)
+	for _, st := range root.AllDescendants(root) {
+		var path = ""
+		for child := st; child.parent != nil; child = child.parent {
+			if child.name != "" {
+				path = Camel(child.name) + path
+			} else {
+				for idx, other := range child.parent.nested {
+					if other == child {
+						path = strconv.Itoa(idx+1) + path
+					}
+				}
+			}
+		}
+		for idx, timer := range st.timers {
+			var name = path + "Timer"
+			if idx != 0 {
+				name += strconv.Itoa(idx + 1)
+			}
+			if prev, found := timers[name]; found {
+				fail(timer.pos, name, "timer "+name+" redeclared, previous declaration at "+prev.pos.String())
+			}
+			timers[name] = timer
+			timer.name = name
+		}
+		for _, ev := range st.events {
+			if ev.IsTimer() {
+				ev.name = st.Timer(ev.after).name
+			}
+		}
+	}
+	for _, name := range root.AllEvents() {
+		if timer, found := timers[name]; found {
+			fail(timer.pos, name, "timer "+name+" has the same name as event "+name)
+		}
+	}
+	var known []string
+	var declared = make(map[string]*State)
+	for _, st := range root.AllDescendants(root) {
+		if prev, found := declared[st.name]; found && st.name != "" {
+			fail(st.pos, st.name, "state "+st.name+" redeclared, previous declaration at "+prev.pos.String())
+			continue
+		}
+		declared[st.name] = st
 		if list, ok := names[st.name]; ok {
-			for _, fn := range list {
-				fn(st)
+			for _, ref := range list {
+				ref.bind(st)
 			}
-			names[st.name] = nil
+			delete(names, st.name)
+		}
+		if st.name != "" {
+			known = append(known, st.name)
 		}
 	}
 	for name, list := range names {
-		if len(list) != 0 {
-			panic("unknown state " + name)
+		var message = "unknown state " + name
+		if similar := Similar(name, known); similar != "" {
+			message += ", did you mean " + similar + "?"
+		}
+		for _, ref := range list {
+			errors = append(errors, &Error{Pos: ref.pos, Token: name, Message: message})
+		}
+	}
+	var undeclared = func(kind, name string, pos scanner.Position) {
+		var names, found = root.declared[kind]
+		if found == false || name == "" {
+			return
 		}
+		for _, str := range names {
+			if str == name {
+				return
+			}
+		}
+		var message = "undeclared " + strings.TrimSuffix(kind, "s") + " " + name
+		if similar := Similar(name, names); similar != "" {
+			message += ", did you mean " + similar + "?"
+		}
+		fail(pos, name, message)
+	}
+	for _, st := range root.AllDescendants(root) {
+		for _, act := range append(append([]string{}, st.entry...), st.exit...) {
+			undeclared("actions", act, st.pos)
+		}
+		for _, ev := range st.events {
+			if ev.IsDone() == false && ev.IsWildcard() == false && ev.IsTimer() == false {
+				undeclared("events", ev.name, ev.pos)
+			}
+			for _, act := range ev.act {
+				undeclared("actions", act, ev.pos)
+			}
+			if ev.cond != nil {
+				for _, cond := range ev.cond.Names() {
+					undeclared("conditions", cond, ev.pos)
+				}
+			}
+		}
+	}
+	var handled = make(map[string]bool)
+	for _, name := range root.AllEvents() {
+		handled[name] = true
+	}
+	for _, st := range root.AllDescendants(root) {
+		var regions = 0
+		for _, child := range st.Children() {
+			if child.region {
+				regions++
+			}
+		}
+		switch {
+		case st.region && st.IsLeaf():
+			fail(st.pos, st.name, "region "+st.name+" without states")
+		case regions == 0:
+		case st.parent == nil || st.region:
+			fail(st.pos, st.name, "regions must be declared inside a state")
+		case regions != len(st.Children()):
+			fail(st.pos, st.name, "state "+st.name+" mixes regions and substates")
+		case st.start != nil:
+			fail(st.pos, st.name, "state "+st.name+" has regions and cannot have a start")
+		}
+		if st.final && (st.nested != nil || st.events != nil || st.entry != nil || st.exit != nil || st.start != nil || st.deferred != nil) {
+			fail(st.pos, st.name, "final state "+st.name+" cannot have substates, events or actions")
+		}
+		if st.choice && (st.nested != nil || st.entry != nil || st.exit != nil || st.start != nil || st.deferred != nil) {
+			fail(st.pos, st.name, "choice "+st.name+" cannot have substates or actions")
+		}
+		if st.start != nil && st.start.choice {
+			fail(st.pos, st.start.name, "start of state "+st.name+" cannot be choice "+st.start.name)
+		}
+		if st.deferred != nil && st.Region().IsRegion() {
+			fail(st.pos, st.name, "state "+st.name+" in region "+st.Region().name+" cannot defer events")
+		}
+		for _, name := range st.deferred {
+			if st.EventsGrouped()[name] != nil {
+				fail(st.pos, name, "event "+name+" is both handled and deferred in state "+st.name)
+			} else if handled[name] == false {
+				fail(st.pos, name, "deferred event "+name+" is never handled")
+			}
+		}
+		var fallback = false
+		for _, ev := range st.Events() {
+			switch {
+			case ev.branch && st.choice == false:
+				fail(ev.pos, st.name, "branch outside of choice")
+			case ev.branch == false && st.choice:
+				fail(ev.pos, ev.name, "choice "+st.name+" can only have if and else branches")
+			case ev.branch && ev.Dst() != nil && ev.Dst().choice:
+				fail(ev.pos, ev.Dst().name, "choice "+st.name+" cannot branch to choice "+ev.Dst().name)
+			case ev.branch && ev.IsHistory():
+				fail(ev.pos, ev.Dst().name, "choice "+st.name+" cannot branch to history")
+			}
+			fallback = fallback || ev.fallback
+		}
+		if st.choice && fallback == false {
+			fail(st.pos, st.name, "choice "+st.name+" without else")
+		}
+		var completes = len(st.Finals()) != 0
+		if st.IsParallel() {
+			completes = true
+			for _, region := range st.Children() {
+				completes = completes && len(region.Finals()) != 0
+			}
+		}
+		for _, ev := range st.Events() {
+			if ev.IsDone() && completes == false {
+				fail(ev.pos, ev.name, "event done in state "+st.name+" without final states")
+			}
+			if ev.IsDone() && ev.params != nil {
+				fail(ev.pos, ev.name, "event done cannot have parameters")
+			}
+			if ev.IsWildcard() && ev.params != nil {
+				fail(ev.pos, ev.name, "event * cannot have parameters")
+			}
+			if ev.Dst() == nil {
+				continue
+			}
+			if src, dst := st.Diff(ev.Dst()); len(src) != 0 && len(dst) != 0 && src[0].region && dst[0].region {
+				fail(ev.pos, ev.Dst().name, "transition from region "+src[0].name+" to region "+dst[0].name)
+			}
+		}
+	}
+	for _, st := range root.AllHistories() {
+		if st.IsLeaf() {
+			fail(st.pos, st.name, "history of state "+st.name+" without substates")
+		}
+		for _, child := range st.AllDescendants() {
+			if child.region {
+				fail(st.pos, st.name, "history of state "+st.name+" with regions")
+				break
+			}
+		}
+	}
+	var checked = make(map[*State]bool)
+	var check = func(st *State) {
+		if st != nil && checked[st] == false && (st.start != nil || started[st] == false) {
+			checked[st] = true
+			_, err := st.ResolveStart()
+			errors.Add(err)
+		}
+	}
+	check(root)
+	for _, st := range root.AllDescendants(root) {
+		if st.Start() != nil || st.region {
+			check(st)
+		}
+		for _, ev := range st.Events() {
+			check(ev.Dst())
+			if ev.Dst() != nil && ev.IsHistory() && ev.IsDeepHistory() == false {
+				for _, child := range ev.Dst().Children() {
+					check(child)
+				}
+			}
+		}
+	}
+	errors = append(errors, CheckParams(root)...)
+	if err := errors.Err(); err != nil {
+		return nil, err
 	}
-	return root
+	return root, nil
 }
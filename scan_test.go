@@ -0,0 +1,239 @@
+package smc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		src    string
+		errors []string
+	}{
+		{"", []string{"test.sm:1:1: unexpected EOF"}},
+		{"x.M {\n\tstart A;\n\tstate A;\n", []string{"test.sm:4:1: unexpected EOF"}},
+		{"x.M {\n\tstart A;\n\tstate A;\n}\n}", []string{"test.sm:5:1: unexpected }, expecting EOF"}},
+		{"x.M {\n\tstart A;\n\tstate A { event E { dst B; } }\n}", []string{"test.sm:3:26: unknown state B"}},
+		{"x.M {\n\tstart A;\n\tstate A;\n\tstate A;\n}", []string{"test.sm:4:8: state A redeclared, previous declaration at test.sm:3:8"}},
+		{"x.M {\n\tstart A;\n\tstate A { event after 5x { dst A; } }\n}", []string{"test.sm:3:25: unknown time unit x, expecting ms, s, m or h"}},
+		{"x.M {\n\tstart A;\n\tstate A { event E if (C && { act X; } }\n}", []string{"test.sm:3:29: unexpected {, expecting !, (, identifier"}},
+		{
+			"x.M {\n\tstart A;\n\tstate A { event E { dst ; } }\n\tstate B { event F { foo; } }\n}",
+			[]string{"test.sm:3:26: unexpected ;, expecting !, identifier", "test.sm:4:22: unexpected foo, expecting ;, act, dst, }"},
+		},
+		{
+			"x.M {\n\tstart Z;\n\tstate B { event E { dst Q; } }\n\tstate A;\n}",
+			[]string{"test.sm:2:8: unknown state Z", "test.sm:3:26: unknown state Q"},
+		},
+	}
+	for _, test := range tests {
+		var root, err = Parse(strings.NewReader(test.src), "test.sm")
+		if root != nil {
+			t.Errorf("%q: expecting no state machine", test.src)
+		}
+		var list, ok = err.(ErrorList)
+		if ok == false {
+			t.Errorf("%q: expecting ErrorList, got %T", test.src, err)
+			continue
+		}
+		var got []string
+		for _, err := range list {
+			got = append(got, err.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.errors, "\n") {
+			t.Errorf("%q: expecting\n%s\ngot\n%s", test.src, strings.Join(test.errors, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestParseErrorFields(t *testing.T) {
+	var _, err = Parse(strings.NewReader("x.M {\n\tstart A;\n\tstate A { event E { foo; } }\n}"), "test.sm")
+	var list, ok = err.(ErrorList)
+	if ok == false || len(list) != 1 {
+		t.Fatalf("expecting one error, got %v", err)
+	}
+	var first = list[0]
+	if first.Pos.Filename != "test.sm" || first.Pos.Line != 3 || first.Pos.Column != 22 {
+		t.Errorf("unexpected position %s", first.Pos)
+	}
+	if first.Token != "foo" {
+		t.Errorf("unexpected token %q", first.Token)
+	}
+	if expected := strings.Join(first.Expected, " "); expected != "; act dst }" {
+		t.Errorf("unexpected expected tokens %q", expected)
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	var src = `x.M {
+	events { Go, Stop }
+	actions { Log }
+	template Retry(Target) {
+		state Wait { event after 1s { dst Target; } }
+	}
+	start Idle;
+	state Idle {
+		defer Stop;
+		event Go if A && !(B || C) { dst Run; act Log; }
+		event Go else;
+	}
+	state Run {
+		region Left { start L; state L { event Stop { dst! Done; } } }
+		region Right { start R; use Retry(R) as Right; state R; }
+	}
+	choice Pick { if A { dst Idle; } else { dst Run; } }
+	final Done;
+	event * { act Log; }
+}`
+	if _, err := Parse(strings.NewReader(src), "test.sm"); err != nil {
+		t.Fatal(err)
+	}
+	for idx := range src {
+		var _, err = Parse(strings.NewReader(src[:idx]), "test.sm")
+		if err == nil {
+			t.Errorf("%d: expecting error for truncated source", idx)
+		}
+	}
+}
+
+func TestParseMalformedTemplate(t *testing.T) {
+	var tests = []struct {
+		src     string
+		message string
+	}{
+		{"x.M {\n\ttemplate Retry {\n\t}\n}", "unterminated template Retry"},
+		{"x.M {\n\tstart A;\n\tstate A;\n\ttemplate Retry(T) }\n}", "unterminated template Retry"},
+	}
+	for _, test := range tests {
+		var _, err = Parse(strings.NewReader(test.src), "test.sm")
+		if err == nil {
+			t.Errorf("%q: expecting error", test.src)
+			continue
+		}
+		if strings.Contains(err.Error(), test.message) == false {
+			t.Errorf("%q: expecting %q, got %q", test.src, test.message, err)
+		}
+	}
+}
+
+func TestParseTemplateRename(t *testing.T) {
+	var src = `x.M {
+	template Guard(Target) {
+		state Timeout {
+			event Timeout { dst! Target; act Timeout; }
+		}
+	}
+	start ConnTimeout;
+	use Guard(Up) as Conn;
+	state Up;
+}`
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := strings.Join(root.AllEvents(), ","); events != "Timeout" {
+		t.Errorf("expecting events Timeout, got %s", events)
+	}
+	if actions := strings.Join(root.AllActions(), ","); actions != "Timeout" {
+		t.Errorf("expecting actions Timeout, got %s", actions)
+	}
+	if start := root.Start(); start == nil || start.Name() != "ConnTimeout" {
+		t.Errorf("expecting start ConnTimeout, got %v", start)
+	}
+}
+
+func TestParseHistoryStart(t *testing.T) {
+	var src = `x.M {
+	start P;
+	state P {
+		start A;
+		state A { event Go { dst B1; } }
+		state B {%s state B1; state B2; }
+	}
+	state Q { event Back { dst P.history; } }
+}`
+	var _, err = Parse(strings.NewReader(fmt.Sprintf(src, "")), "test.sm")
+	if err == nil || err.Error() != "test.sm:6:9: B: missing start" {
+		t.Errorf("expecting missing start, got %v", err)
+	}
+	root, err := Parse(strings.NewReader(fmt.Sprintf(src, " start B2;")), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range root.AllDescendants(root) {
+		for _, event := range state.Events() {
+			MakeBranches(event)
+		}
+	}
+}
+
+func TestParseTimerNames(t *testing.T) {
+	var src = `x.M {
+	start A;
+	state A { event after 1s { dst B; } event after 2s if C { dst B; } event after 2s else { dst A; } }
+	state B { event AAfter1s { dst A; } }
+	state { start D; state D { event after 5s { dst A; } } }
+	event after 1m { dst A; }
+}`
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := strings.Join(root.AllEvents(), ","); events != "AAfter1s" {
+		t.Errorf("expecting events AAfter1s, got %s", events)
+	}
+	var names []string
+	for _, timer := range root.AllTimers() {
+		names = append(names, timer.Name()+"="+timer.Timeout().String())
+	}
+	if list := strings.Join(names, ","); list != "Timer=1m0s,ATimer=1s,ATimer2=2s,3DTimer=5s" {
+		t.Errorf("unexpected timers %s", list)
+	}
+	_, err = Parse(strings.NewReader("x.M {\n\tstart A;\n\tstate A { event after 1s { dst A; } event ATimer { dst A; } }\n}"), "test.sm")
+	if err == nil || strings.Contains(err.Error(), "timer ATimer has the same name as event ATimer") == false {
+		t.Errorf("expecting collision error, got %v", err)
+	}
+}
+
+func TestParseDeclarations(t *testing.T) {
+	var src = "x.M {\n\tevents { Go }\n\tactions { Connect }\n\tconditions { Ready }\n\tstart A;\n\tstate A { event Goo if Redy { act Conect; } }\n}"
+	var want = []string{
+		"test.sm:6:18: undeclared event Goo, did you mean Go?",
+		"test.sm:6:18: undeclared action Conect, did you mean Connect?",
+		"test.sm:6:18: undeclared condition Redy, did you mean Ready?",
+	}
+	var _, err = Parse(strings.NewReader(src), "test.sm")
+	if err == nil || err.Error() != strings.Join(want, "\n") {
+		t.Errorf("expecting\n%s\ngot\n%v", strings.Join(want, "\n"), err)
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	var dir = t.TempDir()
+	var files = map[string]string{
+		"common/reconnect.sm": "state Reconnect {\n\tevent Up { dst Idl; }\n}\n",
+		"common/loop.sm":      "include \"../main.sm\";\n",
+		"main.sm":             "x.M {\n\tstart Idle;\n\tstate Idle { event Drop { dst Reconnect; } }\n\tinclude \"common/reconnect.sm\";\n\tinclude \"common/loop.sm\";\n}\n",
+	}
+	for name, text := range files {
+		var path = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var filename = filepath.Join(dir, "main.sm")
+	var _, err = Parse(strings.NewReader(files["main.sm"]), filename)
+	var want = []string{
+		filepath.Join(dir, "common", "loop.sm") + ":1:9: include cycle " + filename + " -> " + filepath.Join(dir, "common", "loop.sm") + " -> " + filename,
+		filepath.Join(dir, "common", "reconnect.sm") + ":2:17: unknown state Idl, did you mean Idle?",
+	}
+	if err == nil || err.Error() != strings.Join(want, "\n") {
+		t.Errorf("expecting\n%s\ngot\n%v", strings.Join(want, "\n"), err)
+	}
+}
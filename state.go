@@ -2,17 +2,33 @@ package smc
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"text/scanner"
 )
 
 type State struct {
-	name   string
-	start  *State
-	parent *State
-	entry  []string
-	exit   []string
-	nested []*State
-	events []*Event
+	name      string
+	pos       scanner.Position
+	start     *State
+	parent    *State
+	entry     []string
+	exit      []string
+	deferred  []string
+	nested    []*State
+	events    []*Event
+	timers    []*Event
+	ignore    []string
+	region    bool
+	final     bool
+	choice    bool
+	spliced   bool
+	template  bool
+	params    []string
+	include   []string
+	uses      []string
+	templates []*State
+	declared  map[string][]string
 }
 
 func (state *State) Name() string {
@@ -23,17 +39,29 @@ func (state *State) Start() *State {
 	return state.start
 }
 
+func (state *State) Pos() scanner.Position {
+	return state.pos
+}
+
 func (state *State) FollowStart() *State {
-	if state.IsLeaf() {
-		return state
+	var leaf, err = state.ResolveStart()
+	if err != nil {
+		panic(err)
+	}
+	return leaf
+}
+
+func (state *State) ResolveStart() (*State, error) {
+	if state.IsLeaf() || state.IsParallel() {
+		return state, nil
 	}
 	if state.start == nil {
-		panic(state.Name() + ": missing start")
+		return nil, &Error{Pos: state.pos, Token: state.name, Message: state.Name() + ": missing start"}
 	}
-	if state.start.IsDescendantOf(state) {
-		return state.start.FollowStart()
+	if state.start != state && state.start.IsDescendantOf(state) {
+		return state.start.ResolveStart()
 	}
-	panic(state.Name() + ": invalid start")
+	return nil, &Error{Pos: state.pos, Token: state.name, Message: state.Name() + ": invalid start"}
 }
 func (state *State) Parent() *State {
 	return state.parent
@@ -47,6 +75,23 @@ func (state *State) Exit() []string {
 	return state.exit
 }
 
+func (state *State) Deferred() []string {
+	return state.deferred
+}
+
+func (state *State) Defers(name string) bool {
+	for _, str := range state.deferred {
+		if str == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *State) Timers() []*Event {
+	return state.timers
+}
+
 func (state *State) Children() []*State {
 	return state.nested
 }
@@ -67,6 +112,54 @@ func (state *State) IsLeaf() bool {
 	return len(state.nested) == 0
 }
 
+func (state *State) IsRegion() bool {
+	return state.region
+}
+
+func (state *State) IsFinal() bool {
+	return state.final
+}
+
+func (state *State) Finals() []*State {
+	var finals []*State
+	for _, child := range state.nested {
+		if child.final {
+			finals = append(finals, child)
+		}
+	}
+	return finals
+}
+
+func (state *State) IsChoice() bool {
+	return state.choice
+}
+
+func (state *State) IsParallel() bool {
+	return len(state.nested) != 0 && state.nested[0].region
+}
+
+func (state *State) IsAtomic() bool {
+	return (state.IsLeaf() && state.choice == false) || state.IsParallel()
+}
+
+func (state *State) Region() *State {
+	if state.region || state.parent == nil {
+		return state
+	}
+	return state.parent.Region()
+}
+
+func (state *State) Declares(name string) bool {
+	for _, st := range state.AllDescendants() {
+		for _, event := range st.Events() {
+			if event.Name() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (state *State) IsDescendantOf(other *State) bool {
 	if state == other {
 		return true
@@ -116,6 +209,19 @@ func (state *State) EventsGrouped() map[string][]*Event {
 	return groups
 }
 
+func (root *State) Handlers(state *State) map[string][]*Event {
+	var groups = state.EventsGrouped()
+	if groups["*"] == nil {
+		return groups
+	}
+	for _, name := range root.AllEvents() {
+		if groups[name] == nil && state.Defers(name) == false {
+			groups[name] = groups["*"]
+		}
+	}
+	return groups
+}
+
 func (state *State) AddEvent(event *Event) bool {
 	for _, ev := range state.events {
 		if event.Same(ev) {
@@ -131,14 +237,21 @@ func (state *State) PushEvents() {
 		child.PushEvents()
 	}
 	for _, child := range state.AllDescendants() {
+		if child.Region() != state.Region() || child.choice || (child.final && child.parent.parent == nil) {
+			continue
+		}
+		for _, name := range state.deferred {
+			if child.EventsGrouped()[name] == nil && child.Defers(name) == false {
+				child.deferred = append(child.deferred, name)
+			}
+		}
 		for _, event := range state.Events() {
-			child.AddEvent(&Event{
-				event.name,
-				event.cond,
-				child,
-				event.dst,
-				event.act,
-			})
+			if event.IsDone() || child.Defers(event.Name()) {
+				continue
+			}
+			var copy = *event
+			copy.src = child
+			child.AddEvent(&copy)
 		}
 	}
 }
@@ -147,21 +260,86 @@ func (root *State) AllEvents() []string {
 	var all []string
 	for _, state := range root.AllDescendants(root) {
 		for _, event := range state.Events() {
-			all = append(all, event.Name())
+			if event.IsDone() == false && event.IsBranch() == false && event.IsWildcard() == false && event.IsTimer() == false {
+				all = append(all, event.Name())
+			}
 		}
 	}
+	all = append(all, root.declared["events"]...)
 	return StringSet(all)
 }
 
+func (root *State) AllSignals() []string {
+	var all = root.AllEvents()
+	for _, timer := range root.AllTimers() {
+		all = append(all, timer.Name())
+	}
+	return all
+}
+
 func (root *State) AllConditions() []string {
 	var all []string
 	for _, state := range root.AllDescendants(root) {
 		for _, event := range state.Events() {
 			if event.HasCond() {
-				all = append(all, event.Cond())
+				all = append(all, event.Cond().Names()...)
 			}
 		}
 	}
+	all = append(all, root.declared["conditions"]...)
+	return StringSet(all)
+}
+
+func (root *State) AllHistories() []*State {
+	var all []*State
+	var found = make(map[*State]bool)
+	for _, state := range root.AllDescendants(root) {
+		for _, event := range state.Events() {
+			if event.IsHistory() {
+				found[event.Dst()] = true
+			}
+		}
+	}
+	for _, state := range root.AllDescendants(root) {
+		if found[state] {
+			all = append(all, state)
+		}
+	}
+	return all
+}
+
+func (root *State) AllRegions() []*State {
+	var all []*State
+	for _, state := range root.AllDescendants(root) {
+		if state.region {
+			all = append(all, state)
+		}
+	}
+	return all
+}
+
+func (state *State) Timer(after string) *Event {
+	for _, timer := range state.timers {
+		if timer.after == after {
+			return timer
+		}
+	}
+	return nil
+}
+
+func (root *State) AllTimers() []*Event {
+	var all []*Event
+	for _, state := range root.AllDescendants(root) {
+		all = append(all, state.Timers()...)
+	}
+	return all
+}
+
+func (root *State) AllDeferred() []string {
+	var all []string
+	for _, state := range root.AllDescendants(root) {
+		all = append(all, state.Deferred()...)
+	}
 	return StringSet(all)
 }
 
@@ -174,6 +352,7 @@ func (root *State) AllActions() []string {
 		all = append(all, state.Entry()...)
 		all = append(all, state.Exit()...)
 	}
+	all = append(all, root.declared["actions"]...)
 	return StringSet(all)
 }
 
@@ -181,31 +360,55 @@ func PrintState(state *State, indent string) (lines []string) {
 	var line = func(format string, args ...interface{}) {
 		lines = append(lines, fmt.Sprintf(format, args...))
 	}
-	if state.start != nil || state.entry != nil || state.exit != nil || state.nested != nil || state.events != nil {
+	lines = PrintIgnore(state.ignore, indent)
+	if state.start != nil || state.entry != nil || state.exit != nil || state.deferred != nil || state.include != nil || state.uses != nil || state.nested != nil || state.events != nil || state.template {
 		if state.name == "" {
 			line("%sstate {", indent)
+		} else if state.region {
+			line("%sregion %s {", indent, state.name)
+		} else if state.choice {
+			line("%schoice %s {", indent, state.name)
+		} else if state.template {
+			line("%stemplate %s(%s) {", indent, state.name, strings.Join(state.params, ", "))
 		} else {
 			line("%sstate %s {", indent, state.name)
 		}
+		for _, name := range state.include {
+			line("%s\tinclude %s;", indent, strconv.Quote(name))
+		}
 		if state.entry != nil {
 			line("%s\tentry %s;", indent, strings.Join(state.entry, ", "))
 		}
 		if state.exit != nil {
 			line("%s\texit %s;", indent, strings.Join(state.exit, ", "))
 		}
+		if state.deferred != nil {
+			line("%s\tdefer %s;", indent, strings.Join(state.deferred, ", "))
+		}
 		if state.start != nil {
 			line("%s\tstart %s;", indent, state.start.name)
 		}
 		for _, st := range state.nested {
-			lines = append(lines, PrintState(st, indent+"\t")...)
+			if st.spliced == false {
+				lines = append(lines, PrintState(st, indent+"\t")...)
+			}
+		}
+		for _, use := range state.uses {
+			line("%s\tuse %s;", indent, use)
 		}
 		for _, ev := range state.events {
-			lines = append(lines, PrintEvent(ev, indent+"\t")...)
+			if ev.spliced == false {
+				lines = append(lines, PrintEvent(ev, indent+"\t")...)
+			}
 		}
 		line("%s}", indent)
 		return
 	}
-	line("%sstate %s;", indent, state.name)
+	if state.final {
+		line("%sfinal %s;", indent, state.name)
+	} else {
+		line("%sstate %s;", indent, state.name)
+	}
 	return
 }
 
@@ -214,20 +417,43 @@ func PrintRoot(state *State, indent string) (lines []string) {
 		lines = append(lines, fmt.Sprintf(format, args...))
 	}
 	line("%s%s {", indent, state.name)
+	for _, kind := range []string{"events", "actions", "conditions"} {
+		if names, found := state.declared[kind]; found {
+			line("%s\t%s { %s }", indent, kind, strings.Join(names, ", "))
+		}
+	}
+	for _, name := range state.include {
+		line("%s\tinclude %s;", indent, strconv.Quote(name))
+	}
+	for _, st := range state.templates {
+		if st.spliced == false {
+			lines = append(lines, PrintState(st, indent+"\t")...)
+		}
+	}
 	if state.entry != nil {
 		line("%s\tentry %s;", indent, strings.Join(state.entry, ", "))
 	}
 	if state.exit != nil {
 		line("%s\texit %s;", indent, strings.Join(state.exit, ", "))
 	}
+	if state.deferred != nil {
+		line("%s\tdefer %s;", indent, strings.Join(state.deferred, ", "))
+	}
 	if state.start != nil {
 		line("%s\tstart %s;", indent, state.start.name)
 	}
 	for _, st := range state.nested {
-		lines = append(lines, PrintState(st, indent+"\t")...)
+		if st.spliced == false {
+			lines = append(lines, PrintState(st, indent+"\t")...)
+		}
+	}
+	for _, use := range state.uses {
+		line("%s\tuse %s;", indent, use)
 	}
 	for _, ev := range state.events {
-		lines = append(lines, PrintEvent(ev, indent+"\t")...)
+		if ev.spliced == false {
+			lines = append(lines, PrintEvent(ev, indent+"\t")...)
+		}
 	}
 	line("%s}", indent)
 	return
@@ -0,0 +1,47 @@
+package smc
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHandlersWildcard(t *testing.T) {
+	var src = `x.M {
+	start A;
+	event * { dst C; }
+	state A {
+		event Go { dst B; }
+		event after 1s { dst B; }
+	}
+	state B {
+		defer Ping;
+		event Stop { dst A; }
+	}
+	state C {
+		event Ping { dst A; }
+	}
+}`
+	var root, err = Parse(strings.NewReader(src), "test.sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.PushEvents()
+	var want = []string{
+		"ATimer:B Go:B Ping:C Stop:C",
+		"Go:C Stop:A",
+		"Go:C Ping:A Stop:C",
+	}
+	for i, state := range root.Children() {
+		var list []string
+		for name, group := range root.Handlers(state) {
+			if name != "*" {
+				list = append(list, name+":"+group[0].Dst().Name())
+			}
+		}
+		sort.Strings(list)
+		if got := strings.Join(list, " "); got != want[i] {
+			t.Errorf("state %s: expecting %q, got %q", state.Name(), want[i], got)
+		}
+	}
+}
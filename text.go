@@ -41,3 +41,56 @@ func StringSet(list []string) []string {
 	sort.Strings(list)
 	return list
 }
+
+func Blank(text string) string {
+	return strings.Map(func(chr rune) rune {
+		if chr == '\n' {
+			return chr
+		}
+		return ' '
+	}, text)
+}
+
+func Distance(a, b string) int {
+	var src, dst = []rune(a), []rune(b)
+	var dist = make([][]int, len(src)+1)
+	for i := range dist {
+		dist[i] = make([]int, len(dst)+1)
+		dist[i][0] = i
+	}
+	for j := range dist[0] {
+		dist[0][j] = j
+	}
+	var min = func(a, b int) int {
+		if a < b {
+			return a
+		}
+		return b
+	}
+	for i := 1; i <= len(src); i++ {
+		for j := 1; j <= len(dst); j++ {
+			var cost = 1
+			if src[i-1] == dst[j-1] {
+				cost = 0
+			}
+			dist[i][j] = min(min(dist[i-1][j]+1, dist[i][j-1]+1), dist[i-1][j-1]+cost)
+			if i > 1 && j > 1 && src[i-1] == dst[j-2] && src[i-2] == dst[j-1] {
+				dist[i][j] = min(dist[i][j], dist[i-2][j-2]+1)
+			}
+		}
+	}
+	return dist[len(src)][len(dst)]
+}
+
+func Similar(text string, list []string) string {
+	var best, limit = "", len([]rune(text)) / 3
+	for _, str := range list {
+		if strings.EqualFold(str, text) {
+			return str
+		}
+		if dist := Distance(strings.ToLower(str), strings.ToLower(text)); dist <= limit {
+			best, limit = str, dist-1
+		}
+	}
+	return best
+}
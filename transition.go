@@ -1,35 +1,199 @@
 package smc
 
-func MakeEntry(path []*State) ([]string, *State) {
+type Step struct {
+	Act   string
+	Args  []Param
+	Exit  *State
+	Start *Event
+	Stop  *Event
+}
+
+type Branch struct {
+	Keys  []*State
+	Cond  *Cond
+	Steps []Step
+	Dsts  []*State
+}
+
+func MakeEntry(path []*State) ([]Step, []*State) {
 	if len(path) == 0 {
 		return nil, nil
 	}
-	if path[0].IsLeaf() {
-		return path[0].Entry(), path[0]
+	var state = path[0]
+	var acts = MakeSteps(state.Entry())
+	for _, timer := range state.Timers() {
+		acts = append(acts, Step{Start: timer})
+	}
+	if state.IsLeaf() {
+		return acts, []*State{state}
+	}
+	if state.IsParallel() {
+		var dsts = []*State{state}
+		for _, region := range state.Children() {
+			var sub = region.FollowStart().Path()[len(state.Path()):]
+			if len(path) > 1 && path[1] == region {
+				sub = path[1:]
+			}
+			var act, dst = MakeEntry(sub)
+			acts = append(acts, act...)
+			dsts = append(dsts, dst...)
+		}
+		return acts, dsts
 	}
 	var act, dst = MakeEntry(path[1:])
-	return append(path[0].Entry(), act...), dst
+	return append(acts, act...), dst
 }
 
-func MakeExit(path []*State) []string {
+func MakeExit(path []*State) []Step {
 	if len(path) == 0 {
 		return nil
 	}
-	return append(MakeExit(path[1:]), path[0].Exit()...)
+	var state = path[0]
+	var steps []Step
+	if state.IsParallel() {
+		var regions = state.Children()
+		for idx := len(regions) - 1; idx >= 0; idx-- {
+			if len(path) > 1 && path[1] == regions[idx] {
+				steps = append(steps, MakeExit(path[1:])...)
+			} else {
+				steps = append(steps, Step{Exit: regions[idx]})
+			}
+		}
+	} else {
+		steps = MakeExit(path[1:])
+	}
+	for _, timer := range state.Timers() {
+		steps = append(steps, Step{Stop: timer})
+	}
+	for _, act := range state.Exit() {
+		steps = append(steps, Step{Act: act})
+	}
+	return steps
+}
+
+func MakeSteps(actions []string) []Step {
+	var steps []Step
+	for _, act := range actions {
+		steps = append(steps, Step{Act: act})
+	}
+	return steps
+}
+
+func MakeActions(event *Event) []Step {
+	var steps []Step
+	for idx, act := range event.Actions() {
+		steps = append(steps, Step{Act: act, Args: event.Args(idx)})
+	}
+	return steps
+}
+
+func MakeTransitionTo(event *Event, leaf *State) ([]Step, []*State) {
+	var expath, enpath = event.Src().Diff(leaf)
+	if event.IsExternal() && event.Src().IsDescendantOf(event.Dst()) {
+		var depth = len(event.Dst().Path()) - 1
+		expath, enpath = event.Src().Path()[depth:], leaf.Path()[depth:]
+	}
+	var steps = MakeExit(expath)
+	var dsts []*State
+	if len(expath) == 0 && len(enpath) != 0 && event.Src().IsParallel() {
+		steps = append(steps, Step{Exit: enpath[0]})
+		dsts = append(dsts, event.Src())
+	}
+	steps = append(steps, MakeActions(event)...)
+	var entry, dst = MakeEntry(enpath)
+	steps = append(steps, entry...)
+	dsts = append(dsts, dst...)
+	if len(dsts) == 0 {
+		dsts = append(dsts, leaf)
+	}
+	return steps, dsts
+}
+
+func MakeChoice(event *Event, branch *Event) *Event {
+	var copy = *event
+	copy.dst = branch.dst
+	copy.history = branch.history
+	copy.external = branch.external
+	copy.act = append(append([]string{}, event.act...), branch.act...)
+	copy.args = nil
+	for idx := range event.act {
+		copy.args = append(copy.args, event.Args(idx))
+	}
+	for idx := range branch.act {
+		copy.args = append(copy.args, branch.Args(idx))
+	}
+	return &copy
 }
 
-func MakeTransition(event *Event) ([]string, *State) {
+func MakeTransition(event *Event) ([]Step, []*State) {
+	if event.Dst() != nil && event.Dst().IsChoice() {
+		for _, branch := range event.Dst().Events() {
+			if branch.IsElse() {
+				return MakeTransition(MakeChoice(event, branch))
+			}
+		}
+	}
 	if event.IsInternal() {
-		return event.Actions(), nil
+		return MakeActions(event), nil
 	} else {
-		var expath, enpath = event.Src().Diff(event.Dst().FollowStart())
-		var exit = MakeExit(expath)
-		var actions = event.Actions()
-		var entry, dst = MakeEntry(enpath)
-		return append(exit, append(actions, entry...)...), dst
+		return MakeTransitionTo(event, event.Dst().FollowStart())
+	}
+}
+
+func MakeBranches(event *Event) []Branch {
+	var steps, dsts = MakeTransition(event)
+	if event.Dst() != nil && event.Dst().IsChoice() {
+		var branches []Branch
+		for _, branch := range event.Dst().EventsGrouped()[""] {
+			if branch.IsElse() == false {
+				var steps, dsts = MakeTransition(MakeChoice(event, branch))
+				branches = append(branches, Branch{nil, branch.Cond(), steps, dsts})
+			}
+		}
+		return append(branches, Branch{nil, nil, steps, dsts})
+	}
+	if event.IsHistory() == false {
+		return []Branch{{nil, nil, steps, dsts}}
+	}
+	var branches []Branch
+	var index = make(map[*State]int)
+	var depth = len(event.Dst().Path())
+	for _, leaf := range event.Dst().AllDescendants() {
+		if leaf.IsAtomic() == false {
+			continue
+		}
+		var target = leaf
+		if event.IsDeepHistory() == false {
+			target = leaf.Path()[depth].FollowStart()
+		}
+		if target == event.Dst().FollowStart() {
+			continue
+		}
+		if idx, found := index[target]; found {
+			branches[idx].Keys = append(branches[idx].Keys, leaf)
+			continue
+		}
+		index[target] = len(branches)
+		var steps, dsts = MakeTransitionTo(event, target)
+		branches = append(branches, Branch{[]*State{leaf}, nil, steps, dsts})
+	}
+	return append(branches, Branch{nil, nil, steps, dsts})
+}
+
+func MakeRegionExit(region *State, state *State) []Step {
+	return MakeExit(state.Path()[len(region.Path())-1:])
+}
+
+func MakeCompletion(dst *State) *State {
+	if dst.IsFinal() == false {
+		return nil
+	}
+	if dst.parent.IsRegion() {
+		return dst.parent.parent
 	}
+	return dst.parent
 }
 
-func MakeStart(root *State) ([]string, *State) {
+func MakeStart(root *State) ([]Step, []*State) {
 	return MakeEntry(root.FollowStart().Path())
 }